@@ -5,6 +5,7 @@ import (
 	"embed"
 	"log"
 	"my-ssg/core"
+	"sync"
 )
 
 //go:embed all:frontend
@@ -14,6 +15,9 @@ var assets embed.FS
 type App struct {
 	ctx    context.Context
 	engine *core.Engine // Our core logic
+
+	previewMu sync.Mutex
+	previews  map[string]*core.PreviewServer // Running preview servers, by project name.
 }
 
 func NewApp() *App {
@@ -22,5 +26,32 @@ func NewApp() *App {
 	if err != nil {
 		log.Fatalf("Failed to initialize core engine: %v", err)
 	}
-	return &App{engine: engine}
+	return &App{engine: engine, previews: make(map[string]*core.PreviewServer)}
+}
+
+// startup is called by Wails once the window exists. The context it hands us
+// is what the runtime functions (logging, opening the browser) need.
+func (a *App) startup(ctx context.Context) {
+	a.ctx = ctx
+}
+
+// startPreview returns the preview server of a project, starting one on a free
+// port if none is running yet. It keeps running until the app exits.
+func (a *App) startPreview(projectName string) (*core.PreviewServer, error) {
+	a.previewMu.Lock()
+	defer a.previewMu.Unlock()
+
+	if preview, ok := a.previews[projectName]; ok {
+		return preview, nil
+	}
+	preview, err := a.engine.StartPreview(context.Background(), projectName, core.PreviewOptions{
+		Addr: "127.0.0.1:0",
+		// The preview is where authors check their work before it goes live.
+		Build: core.BuildOptions{Drafts: true, Future: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.previews[projectName] = preview
+	return preview, nil
 }
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"my-ssg/core"
+)
+
+// newFlagSet creates the flag set of a command, with the --json flag every
+// command shares.
+func newFlagSet(name, args string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gossg %s %s\n\nFlags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print machine-readable JSON on stdout")
+	return fs, asJSON
+}
+
+// parseArgs parses flags wherever they appear, so that both
+// `gossg build --json blog` and `gossg build blog --json` work,
+// and returns the remaining positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// publicationFlags adds the flags that include unpublished pages in a build.
+func publicationFlags(fs *flag.FlagSet) (drafts, future, expired *bool) {
+	drafts = fs.Bool("drafts", false, "include pages marked as draft")
+	future = fs.Bool("future", false, "include pages whose publishDate is in the future")
+	expired = fs.Bool("expired", false, "include pages whose expiryDate has passed")
+	return drafts, future, expired
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// interruptContext returns a context that is cancelled on Ctrl+C, so a long
+// build or a running server stops cleanly.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func runProjects(engine *core.Engine, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: projects needs a subcommand (list, add, remove)", errUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		fs, asJSON := newFlagSet("projects list", "")
+		if _, err := parseArgs(fs, args[1:]); err != nil {
+			return err
+		}
+		projects := engine.GetProjects()
+		if *asJSON {
+			if projects == nil {
+				projects = []core.Project{}
+			}
+			return printJSON(projects)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tPATH")
+		for _, p := range projects {
+			fmt.Fprintf(w, "%s\t%s\n", p.Name, p.Path)
+		}
+		return w.Flush()
+
+	case "add":
+		fs, asJSON := newFlagSet("projects add", "<name> [path]")
+		rest, err := parseArgs(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) < 1 || len(rest) > 2 {
+			return fmt.Errorf("%w: projects add expects a name and an optional path", errUsage)
+		}
+		name, path := rest[0], ""
+		if len(rest) == 2 {
+			path = rest[1]
+		}
+		if err := engine.AddProject(name, path); err != nil {
+			return err
+		}
+		project, err := engine.FindProjectByName(name)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(project)
+		}
+		fmt.Printf("Created project '%s' at %s\n", project.Name, project.Path)
+		return nil
+
+	case "remove":
+		fs, asJSON := newFlagSet("projects remove", "<name>")
+		rest, err := parseArgs(fs, args[1:])
+		if err != nil {
+			return err
+		}
+		if len(rest) != 1 {
+			return fmt.Errorf("%w: projects remove expects a project name", errUsage)
+		}
+		if err := engine.RemoveProject(rest[0]); err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(map[string]string{"removed": rest[0]})
+		}
+		fmt.Printf("Removed project '%s' (files were left on disk)\n", rest[0])
+		return nil
+
+	default:
+		return fmt.Errorf("%w: unknown projects subcommand '%s'", errUsage, args[0])
+	}
+}
+
+// buildResult is the machine-readable outcome of `gossg build --json`.
+type buildResult struct {
+	Project string            `json:"project"`
+	OK      bool              `json:"ok"`
+	Report  *core.BuildReport `json:"report,omitempty"`
+	Error   string            `json:"error,omitempty"`
+
+	// TemplateErrors locates the errors of theme templates, with their source.
+	TemplateErrors []*core.TemplateError `json:"templateErrors,omitempty"`
+}
+
+func runBuild(engine *core.Engine, args []string) error {
+	fs, asJSON := newFlagSet("build", "<project>")
+	workers := fs.Int("workers", 0, "number of pages rendered in parallel (0 = one per CPU)")
+	progress := fs.Bool("progress", false, "print build events on stderr as they happen")
+	baseURL := fs.String("base-url", "", "absolute URL the site is published under, used in feeds and permalinks")
+	feedLimit := fs.Int("feed-limit", 0, "maximum number of entries per feed (0 = 20)")
+	feedFull := fs.Bool("feed-full", false, "put the full content of pages into feeds instead of summaries")
+	noFeeds := fs.Bool("no-feeds", false, "do not generate RSS, Atom and JSON feeds")
+	drafts, future, expired := publicationFlags(fs)
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("%w: build expects a project name", errUsage)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	opts := core.BuildOptions{
+		Workers: *workers,
+		BaseURL: *baseURL,
+		Feeds:   core.FeedOptions{Disable: *noFeeds, Limit: *feedLimit, FullContent: *feedFull},
+		Drafts:  *drafts,
+		Future:  *future,
+		Expired: *expired,
+	}
+	if *progress {
+		opts.Progress = func(event core.BuildEvent) {
+			if *asJSON {
+				// One JSON object per line, so tools can follow the build as it runs.
+				data, _ := json.Marshal(event)
+				fmt.Fprintln(os.Stderr, string(data))
+				return
+			}
+			line := string(event.Kind)
+			for _, part := range []string{event.Path, event.Message, event.Error} {
+				if part != "" {
+					line += " " + part
+				}
+			}
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+
+	started := time.Now()
+	report, err := engine.BuildProjectContext(ctx, rest[0], opts)
+
+	if *asJSON {
+		result := buildResult{Project: rest[0], OK: err == nil, Report: report}
+		if err != nil {
+			result.Error = err.Error()
+			result.TemplateErrors = core.TemplateErrors(err)
+		}
+		if jsonErr := printJSON(result); jsonErr != nil {
+			return jsonErr
+		}
+		if err != nil {
+			// The error is already part of the JSON; only the exit code is left to set.
+			return errSilent{err}
+		}
+		return nil
+	}
+
+	if err != nil {
+		if len(core.TemplateErrors(err)) == 0 {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "gossg: %v\n", err)
+		printTemplateErrors(err)
+		return errSilent{err}
+	}
+	fmt.Printf("Built '%s' in %s: %d rebuilt, %d skipped, %d deleted\n",
+		rest[0], time.Since(started).Round(time.Millisecond), report.Rebuilt, report.Skipped, report.Deleted)
+	return nil
+}
+
+func runNew(engine *core.Engine, args []string) error {
+	fs, asJSON := newFlagSet("new", "<project> <title>")
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 2 {
+		return fmt.Errorf("%w: new expects a project name and a title", errUsage)
+	}
+
+	article := &core.Article{
+		FrontMatter: core.ArticleFrontMatter{
+			Title: rest[1],
+			Date:  time.Now(),
+		},
+	}
+	finalPath, err := engine.SaveArticle(rest[0], article, "")
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return printJSON(map[string]string{"project": rest[0], "path": filepath.ToSlash(finalPath)})
+	}
+	fmt.Printf("Created content/%s\n", filepath.ToSlash(finalPath))
+	return nil
+}
+
+func runServe(engine *core.Engine, args []string) error {
+	fs, asJSON := newFlagSet("serve", "<project>")
+	addr := fs.String("addr", "127.0.0.1:1313", "address to listen on")
+	poll := fs.Duration("poll", 500*time.Millisecond, "how often to check the sources for changes")
+	workers := fs.Int("workers", 0, "number of pages rendered in parallel (0 = one per CPU)")
+	drafts, future, expired := publicationFlags(fs)
+	rest, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return fmt.Errorf("%w: serve expects a project name", errUsage)
+	}
+
+	ctx, stop := interruptContext()
+	defer stop()
+
+	preview, err := engine.StartPreview(ctx, rest[0], core.PreviewOptions{
+		Addr:         *addr,
+		PollInterval: *poll,
+		Build:        core.BuildOptions{Workers: *workers, Drafts: *drafts, Future: *future, Expired: *expired},
+		OnBuild: func(report *core.BuildReport, err error) {
+			if *asJSON {
+				result := buildResult{Project: rest[0], OK: err == nil, Report: report}
+				if err != nil {
+					result.Error = err.Error()
+					result.TemplateErrors = core.TemplateErrors(err)
+				}
+				data, _ := json.Marshal(result)
+				fmt.Println(string(data))
+				return
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Build failed: %v\n", err)
+				printTemplateErrors(err)
+				return
+			}
+			fmt.Printf("Built: %d rebuilt, %d skipped, %d deleted\n", report.Rebuilt, report.Skipped, report.Deleted)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		data, _ := json.Marshal(map[string]string{"project": rest[0], "url": preview.URL()})
+		fmt.Println(string(data))
+	} else {
+		fmt.Printf("Serving '%s' on %s with live reload (Ctrl+C to stop)\n", rest[0], preview.URL())
+	}
+	return preview.Wait()
+}
+
+// printTemplateErrors shows the template source around the template errors
+// in err on stderr, once for every place many pages failed at.
+func printTemplateErrors(err error) {
+	seen := make(map[string]bool)
+	for _, tmplErr := range core.TemplateErrors(err) {
+		if len(tmplErr.Source) == 0 || seen[tmplErr.Location()] {
+			continue
+		}
+		seen[tmplErr.Location()] = true
+		fmt.Fprintf(os.Stderr, "\n%s:\n%s", tmplErr.Location(), tmplErr.Excerpt())
+	}
+}
+
+// errSilent wraps an error that was already reported to the user, so run only
+// turns it into an exit code.
+type errSilent struct{ err error }
+
+func (e errSilent) Error() string { return e.err.Error() }
+func (e errSilent) Unwrap() error { return e.err }
@@ -0,0 +1,129 @@
+// Command gossg drives the GoSSG core engine without the desktop window.
+// It is meant for CI pipelines and servers, where the Wails runtime is
+// neither available nor wanted.
+//
+// Usage:
+//
+//	gossg [--config path] [--verbose] <command> [arguments]
+//
+// Commands:
+//
+//	projects list                 List the managed projects
+//	projects add <name> [path]    Create a project and register it
+//	projects remove <name>        Forget a project (files stay on disk)
+//	build <project>               Build a project into its public/ directory
+//	new <project> <title>         Create a new article
+//	serve <project>               Preview a project with live reload
+//
+// Every command accepts --json for machine-readable output on stdout.
+// The exit code is 0 on success, 1 when the command failed and 2 when it was
+// called incorrectly.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"my-ssg/core"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage marks errors caused by a wrong invocation rather than a failed command.
+var errUsage = errors.New("usage error")
+
+const usage = `Usage: gossg [--config path] [--verbose] <command> [arguments]
+
+Commands:
+  projects list                 List the managed projects
+  projects add <name> [path]    Create a project and register it
+  projects remove <name>        Forget a project (files stay on disk)
+  build <project>               Build a project into its public/ directory
+  new <project> <title>         Create a new article
+  serve <project>               Preview a project with live reload
+
+Run 'gossg <command> --help' for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run parses the global flags, dispatches to a command and maps its outcome
+// to an exit code.
+func run(args []string) int {
+	global := flag.NewFlagSet("gossg", flag.ContinueOnError)
+	global.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	configPath := global.String("config", "", "path to projects.json (defaults to the one used by the desktop app)")
+	verbose := global.Bool("verbose", false, "show the engine's log output")
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if global.NArg() == 0 {
+		global.Usage()
+		return exitUsage
+	}
+
+	// The engine logs every step; on the command line that is only wanted on request.
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	engine, err := openEngine(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gossg: %v\n", err)
+		return exitError
+	}
+
+	command, commandArgs := global.Arg(0), global.Args()[1:]
+	switch command {
+	case "projects":
+		err = runProjects(engine, commandArgs)
+	case "build":
+		err = runBuild(engine, commandArgs)
+	case "new":
+		err = runNew(engine, commandArgs)
+	case "serve":
+		err = runServe(engine, commandArgs)
+	case "help":
+		global.Usage()
+		return exitOK
+	default:
+		err = fmt.Errorf("%w: unknown command '%s'", errUsage, command)
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, new(errSilent)):
+		return exitError
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "gossg: %v\n\n%s", err, usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(os.Stderr, "gossg: %v\n", err)
+		return exitError
+	}
+}
+
+// openEngine creates the core engine, optionally backed by a custom projects.json.
+func openEngine(configPath string) (*core.Engine, error) {
+	if configPath == "" {
+		return core.NewEngine()
+	}
+	return core.NewEngineWithConfig(configPath)
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Files the redirects of all aliases are written to when site.yaml asks for
+// them, for hosts that can redirect on the server instead of with a stub page.
+const (
+	netlifyRedirectsFile = "_redirects"
+	nginxRedirectsFile   = "nginx-redirects.map"
+)
+
+// RedirectOptions controls the server side redirect files written next to
+// the stub pages of aliases.
+type RedirectOptions struct {
+	Netlify bool `yaml:"netlify" json:"netlify" toml:"netlify"` // Write a Netlify _redirects file.
+	Nginx   bool `yaml:"nginx" json:"nginx" toml:"nginx"`       // Write nginx-redirects.map for an nginx map block.
+}
+
+// aliasStub is the page written at an alias. Browsers follow the refresh, and
+// search engines follow the canonical link to the page's real address.
+var aliasStub = template.Must(template.New("alias").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>{{ . }}</title>
+    <link rel="canonical" href="{{ . }}">
+    <meta name="robots" content="noindex">
+    <meta charset="utf-8">
+    <meta http-equiv="refresh" content="0; url={{ . }}">
+</head>
+<body>
+    <p>This page has moved to <a href="{{ . }}">{{ . }}</a>.</p>
+</body>
+</html>
+`))
+
+// redirect is an alias and the address it points to.
+type redirect struct {
+	from string // URL path of the alias.
+	to   string // Permalink of the page.
+}
+
+// aliasURL resolves an alias of a page to a URL path. Aliases without a
+// leading slash are relative to the page's directory.
+func aliasURL(page *Page, alias string) string {
+	if !strings.HasPrefix(alias, "/") {
+		alias = path.Join("/", page.dir, alias)
+	}
+	return normalizePermalink(alias, true)
+}
+
+// planAliases schedules a redirect stub for every alias of every page, and the
+// redirect files for Netlify and nginx if they are enabled. An alias whose
+// output another page or file already claimed is left out with a warning: an
+// old URL must not stand in the way of a new page published there.
+func planAliases(state *buildState, content *siteContent) error {
+	var redirects []redirect
+	for _, page := range content.pages {
+		source := "content/" + page.File
+		for _, alias := range page.Aliases {
+			from := aliasURL(page, alias)
+			if from == page.RelPermalink {
+				state.events.emit(EventWarning, source, fmt.Sprintf("alias %s is the page's own URL, ignoring it", alias))
+				continue
+			}
+			if owner, taken := state.owners[filepath.ToSlash(outputPathFor(from))]; taken {
+				state.events.emit(EventWarning, source, fmt.Sprintf("alias %s would overwrite the output of %s, ignoring it", alias, owner))
+				continue
+			}
+			to := page.Permalink
+			redirects = append(redirects, redirect{from: from, to: to})
+
+			_, err := state.planOutput(outputPathFor(from), source, fingerprint("alias", to), func(destPath string) error {
+				outputFile, err := createOutputFile(destPath)
+				if err != nil {
+					return fmt.Errorf("failed to create output file %s: %w", destPath, err)
+				}
+				defer outputFile.Close()
+				return aliasStub.Execute(outputFile, to)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	sort.Slice(redirects, func(i, j int) bool { return redirects[i].from < redirects[j].from })
+
+	if state.opts.Redirects.Netlify {
+		var b strings.Builder
+		for _, r := range redirects {
+			fmt.Fprintf(&b, "%s %s 301\n", r.from, r.to)
+		}
+		if err := planTextFile(state, netlifyRedirectsFile, "redirects", b.String()); err != nil {
+			return err
+		}
+	}
+	if state.opts.Redirects.Nginx {
+		var b strings.Builder
+		b.WriteString("# Generated by GoSSG. Use it inside a map block, e.g.\n")
+		b.WriteString("#   map $uri $gossg_redirect { include /path/to/nginx-redirects.map; }\n")
+		b.WriteString("#   if ($gossg_redirect) { return 301 $gossg_redirect; }\n")
+		for _, r := range redirects {
+			fmt.Fprintf(&b, "%s %s;\n", r.from, r.to)
+		}
+		if err := planTextFile(state, nginxRedirectsFile, "redirects", b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planTextFile schedules writing a generated text file unless it is up to date.
+func planTextFile(state *buildState, relPath, source, text string) error {
+	_, err := state.planOutput(relPath, source, fingerprint("text", text), func(destPath string) error {
+		outputFile, err := createOutputFile(destPath)
+		if err != nil {
+			return fmt.Errorf("failed to create output file %s: %w", destPath, err)
+		}
+		defer outputFile.Close()
+		_, err = io.WriteString(outputFile, text)
+		return err
+	})
+	return err
+}
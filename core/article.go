@@ -10,13 +10,62 @@ import (
 	"log"
 	"path/filepath"
 	"regexp"
+	"slices"
 )
 
 // ArticleFrontMatter defines the structure of our YAML front matter.
 type ArticleFrontMatter struct {
-	Title string    `yaml:"title"`
-	Date  time.Time `yaml:"date"`
-	// We can add more fields here later, like categories, tags, etc.
+	Title      string     `yaml:"title"`
+	Date       time.Time  `yaml:"date"`
+	Tags       StringList `yaml:"tags,omitempty"`
+	Categories StringList `yaml:"categories,omitempty"`
+	Aliases    StringList `yaml:"aliases,omitempty"` // Old URLs that redirect to the article.
+
+	// Params keeps every other front matter key, so that saving an article
+	// from the editor does not drop what the editor has no field for.
+	Params map[string]interface{} `yaml:",inline"`
+}
+
+// StringList is a front matter list that may also be written as a single
+// value, e.g. both `tags: [go, web]` and `tags: go`.
+type StringList []string
+
+// UnmarshalYAML accepts a single scalar as well as a sequence.
+func (l *StringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = nil
+		if value.Value != "" {
+			*l = StringList{value.Value}
+		}
+		return nil
+	}
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// Join returns the values separated by commas, as the editor's chip inputs expect them.
+func (l StringList) Join() string {
+	return strings.Join(l, ",")
+}
+
+// ParseStringList splits a comma separated list, trimming blanks and
+// dropping empty and duplicate entries.
+func ParseStringList(value string) StringList {
+	var values StringList
+	seen := make(map[string]bool)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		values = append(values, v)
+	}
+	return values
 }
 
 // Article represents a fully parsed markdown file.
@@ -46,39 +95,85 @@ func (e *Engine) ParseArticleFile(projectName, filePath string) (*Article, error
 	return article, nil
 }
 
+// SaveArticle writes an article, naming the file after its title. When a
+// changed title renames an existing article and with it changes its URL, the
+// old URL is added to the article's aliases, so links to it keep working.
 func (e *Engine) SaveArticle(projectName string, articleData *Article, originalFilePath string) (string, error) {
-    newSlug := slugify(articleData.FrontMatter.Title)
-    if newSlug == "" {
-        return "", fmt.Errorf("article title cannot be empty or invalid")
-    }
-
-    var finalPath string
-    if originalFilePath == "" {
-        fileName := fmt.Sprintf("%s.md", newSlug)
-        finalPath = filepath.Join("posts", fileName)
-    } else {
-        originalSlug := strings.TrimSuffix(filepath.Base(originalFilePath), ".md")
-        if newSlug != originalSlug {
-            newFileName := fmt.Sprintf("%s.md", newSlug)
-            finalPath = filepath.Join(filepath.Dir(originalFilePath), newFileName)
-        } else {
-            finalPath = originalFilePath
-        }
-    }
-    articleData.FilePath = finalPath
-
-    if err := e.WriteArticleFile(projectName, articleData); err != nil {
-        return "", err
-    }
-
-    if originalFilePath != "" && originalFilePath != finalPath {
-        log.Printf("Renaming article, deleting old file: %s", originalFilePath)
-        oldFullPath := filepath.Join(e.config.Projects[0].Path, "content", originalFilePath) // Simplified for example
-        os.Remove(oldFullPath)
-    }
-
-    return finalPath, nil
+	project, err := e.FindProjectByName(projectName)
+	if err != nil {
+		return "", err
+	}
+
+	newSlug := slugify(articleData.FrontMatter.Title)
+	if newSlug == "" {
+		return "", fmt.Errorf("article title cannot be empty or invalid")
+	}
+
+	var finalPath string
+	if originalFilePath == "" {
+		fileName := fmt.Sprintf("%s.md", newSlug)
+		finalPath = filepath.Join("posts", fileName)
+	} else {
+		originalSlug := strings.TrimSuffix(filepath.Base(originalFilePath), ".md")
+		if newSlug != originalSlug {
+			newFileName := fmt.Sprintf("%s.md", newSlug)
+			finalPath = filepath.Join(filepath.Dir(originalFilePath), newFileName)
+		} else {
+			finalPath = originalFilePath
+		}
+	}
+	articleData.FilePath = finalPath
+	renamed := originalFilePath != "" && originalFilePath != finalPath
+
+	// The old URL has to be worked out before the old file is gone. A file
+	// that cannot be parsed had no URL worth keeping.
+	var oldURL string
+	if renamed {
+		if url, err := contentPermalink(project.Path, originalFilePath); err == nil {
+			oldURL = url
+		} else {
+			log.Printf("Could not determine the old URL of %s, not adding an alias: %v", originalFilePath, err)
+		}
+	}
+
+	if err := e.WriteArticleFile(projectName, articleData); err != nil {
+		return "", err
+	}
+
+	if oldURL != "" && !slices.Contains(articleData.FrontMatter.Aliases, oldURL) {
+		newURL, err := contentPermalink(project.Path, finalPath)
+		// Another page may already live at the old URL; the alias would only
+		// be in its way.
+		var owner string
+		if err == nil && newURL != oldURL {
+			if files, err := e.ListContentFiles(projectName); err == nil {
+				owner = pagePublishedAt(project.Path, oldURL, files, originalFilePath, finalPath)
+			}
+		}
+		switch {
+		case err != nil || newURL == oldURL:
+		case owner != "":
+			log.Printf("Article moved from %s to %s, not adding an alias: %s is published there", oldURL, newURL, owner)
+		default:
+			log.Printf("Article moved from %s to %s, adding an alias", oldURL, newURL)
+			articleData.FrontMatter.Aliases = append(articleData.FrontMatter.Aliases, oldURL)
+			if err := e.WriteArticleFile(projectName, articleData); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if renamed {
+		log.Printf("Renaming article, deleting old file: %s", originalFilePath)
+		oldFullPath := filepath.Join(project.Path, "content", originalFilePath)
+		if err := os.Remove(oldFullPath); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("saved %s but could not delete the old file: %w", finalPath, err)
+		}
+	}
+
+	return finalPath, nil
 }
+
 func (e *Engine) WriteArticleFile(projectName string, article *Article) error {
 	frontMatterBytes, err := yaml.Marshal(&article.FrontMatter)
 	if err != nil {
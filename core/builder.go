@@ -1,150 +1,713 @@
 package core
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
-
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
-	"gopkg.in/yaml.v3"
+	"time"
 )
 
-// Page holds the data for a single rendered page.
-type Page struct {
-	FrontMatter map[string]interface{}
-	Content     template.HTML
+// BuildReport summarises what a build did. Pages whose inputs did not change
+// since the previous build are skipped rather than rendered again.
+type BuildReport struct {
+	Rebuilt int `json:"rebuilt"` // Pages, list pages included, rendered during this build.
+	Skipped int `json:"skipped"` // Pages left untouched because their inputs did not change.
+	Deleted int `json:"deleted"` // Outputs removed because their source no longer exists.
+}
+
+// buildState carries everything a single build run needs to decide what to
+// write and to remember what it wrote.
+type buildState struct {
+	ctx         context.Context
+	projectPath string
+	outputDir   string // The staging directory this run writes into.
+	cache       *buildCache
+	outputs     map[string]string // Fingerprints of every output produced by this run.
+	owners      map[string]string // The source that produces each output, to catch collisions.
+	jobs        []renderJob
+	sitemap     []*Page // Every page rendered by this run, in the order planned.
+	report      BuildReport
+	events      *buildEmitter
+
+	opts         BuildOptions
+	theme        *Theme
+	templateHash string // Hash of the whole template set; every rendered page depends on it.
+	site         *Site
+	siteHash     string // Hash of .Site; every rendered page depends on it, too.
+	dataHash     string // Hash of the data files; only pages whose layouts use .Site.Data depend on it.
+	pagesHash    string // Hash of .Site.Pages; only pages whose layouts use it depend on it.
+	buildID      string // Different for every build; pages whose layouts call now depend on it.
+}
+
+// usesFingerprint returns the hash of what templates with the given uses
+// depend on beyond their page: the data files, the site's pages and, for
+// templates calling now, this very build, which makes them render every time.
+func (s *buildState) usesFingerprint(uses templateUses) string {
+	var parts []string
+	if uses.data {
+		parts = append(parts, "data", s.dataHash)
+	}
+	if uses.pages {
+		parts = append(parts, "pages", s.pagesHash)
+	}
+	if uses.now {
+		parts = append(parts, "now", s.buildID)
+	}
+	return fingerprint(parts...)
+}
+
+// displayPath shortens an absolute source path to its project relative form
+// for progress events.
+func (s *buildState) displayPath(path string) string {
+	if relPath, err := filepath.Rel(s.projectPath, path); err == nil {
+		return filepath.ToSlash(relPath)
+	}
+	return path
+}
+
+// claim registers relPath as an output of this build, produced by source from
+// inputs with the given fingerprint. It reports whether the output actually
+// has to be written, and fails when another source produces the same file.
+func (s *buildState) claim(relPath, source, fp string) (bool, error) {
+	relPath = filepath.ToSlash(relPath)
+	if owner, taken := s.owners[relPath]; taken {
+		return false, fmt.Errorf("both %s and %s would be written to %s", owner, source, relPath)
+	}
+	s.owners[relPath] = source
+	s.outputs[relPath] = fp
+	return !s.cache.upToDate(s.outputDir, relPath, fp), nil
+}
+
+// planPage schedules the rendering of a page or list page unless its output is
+// up to date.
+func (s *buildState) planPage(relPath, source, fp string, render func(destPath string) error) error {
+	scheduled, err := s.planOutput(relPath, source, fp, render)
+	if err != nil {
+		return err
+	}
+	if scheduled {
+		s.report.Rebuilt++
+	} else {
+		s.report.Skipped++
+	}
+	return nil
+}
+
+// planOutput schedules writing a generated file, such as a page or a feed,
+// unless it is up to date, and reports whether it was scheduled.
+func (s *buildState) planOutput(relPath, source, fp string, write func(destPath string) error) (bool, error) {
+	needed, err := s.claim(relPath, source, fp)
+	if err != nil || !needed {
+		return false, err
+	}
+	destPath := filepath.Join(s.outputDir, relPath)
+	s.jobs = append(s.jobs, s.trackedJob(source, EventFileDone, func() error {
+		return write(destPath)
+	}))
+	return true, nil
+}
+
+// planCopy schedules copying a file into the output unless it is up to date.
+// doneKind is the event published once the file was copied.
+func (s *buildState) planCopy(relPath, sourcePath string, doneKind BuildEventKind) error {
+	return s.planCopyFS(relPath, os.DirFS(filepath.Dir(sourcePath)), filepath.Base(sourcePath), s.displayPath(sourcePath), doneKind)
+}
+
+// planCopyFS is planCopy for a file of an fs.FS, such as the built-in theme.
+// source names the file in events and errors.
+func (s *buildState) planCopyFS(relPath string, fsys fs.FS, name, source string, doneKind BuildEventKind) error {
+	sourceHash, err := hashFSFile(fsys, name)
+	if err != nil {
+		return fmt.Errorf("failed to hash file %s: %w", source, err)
+	}
+	needed, err := s.claim(relPath, source, fingerprint("file", sourceHash))
+	if err != nil || !needed {
+		return err
+	}
+	destPath := filepath.Join(s.outputDir, relPath)
+	s.jobs = append(s.jobs, s.trackedJob(source, doneKind, func() error {
+		return copyFSFile(fsys, name, destPath)
+	}))
+	return nil
+}
+
+// BuildOptions tunes a single build run. The zero value is a sensible default;
+// options left at their zero value are taken from the project's site.yaml.
+type BuildOptions struct {
+	// Workers is the number of pages rendered concurrently.
+	// Zero or less means one worker per available CPU (GOMAXPROCS).
+	Workers int
+
+	// Taxonomies names the front matter keys that classify pages, e.g.
+	// "tags". Empty means the default "tags" and "categories".
+	Taxonomies []string
+
+	// PageSize is the number of pages per list page before it is paginated.
+	// A section's _index.md can override it with a `paginate` key.
+	// Zero means the default of 10.
+	PageSize int
+
+	// BaseURL is the absolute URL the site is published under, e.g.
+	// "https://example.com/". Feeds need it for their links; without it
+	// every Permalink stays a relative URL.
+	BaseURL string
+
+	// Feeds controls the RSS, Atom and JSON feeds of the site, its sections
+	// and its terms.
+	Feeds FeedOptions
+
+	// Sitemap controls sitemap.xml and robots.txt.
+	Sitemap SitemapOptions
+
+	// Redirects turns on server side redirect files for the aliases of pages.
+	// Redirect stub pages are always written.
+	Redirects RedirectOptions
+
+	// Highlight controls the highlighting of code blocks and its stylesheet.
+	Highlight HighlightOptions
+
+	// Headings sets the depth of .TableOfContents and turns on anchor links.
+	Headings HeadingOptions
+
+	// SummaryLength is the number of words in a summary made from the start
+	// of a page's content.
+	SummaryLength int
+
+	// Drafts, Future and Expired include pages that are drafts, have a
+	// publishDate in the future or an expiryDate in the past. Normal builds
+	// leave them out; previews may want to see them.
+	Drafts  bool
+	Future  bool
+	Expired bool
+
+	// Progress, if set, receives every event of this build as it happens.
+	// Calls are serialised, so the callback does not need its own locking.
+	Progress func(BuildEvent)
+
+	// preview builds into previewDir instead of public/. Only the preview
+	// server sets it, so drafts it shows never reach the published site.
+	preview bool
 }
 
 // BuildProject is the main method for generating the static site for a given project.
-func (e *Engine) BuildProject(projectName string) error {
+// Only pages whose source, template set or theme assets changed since the last build
+// are rendered again, along with the pages that show them: the pages of the same
+// section or term, and those whose templates use .Site.Pages, .Site.Data or now.
+// Outputs whose source disappeared are removed.
+func (e *Engine) BuildProject(projectName string) (*BuildReport, error) {
+	return e.BuildProjectContext(context.Background(), projectName, BuildOptions{})
+}
+
+// BuildProjectContext builds a project like BuildProject, rendering pages on a
+// pool of workers sized by opts.Workers. A page that fails to render does not
+// stop the others; all failures are reported together once the pool is done.
+// Cancelling ctx stops the build promptly and leaves public/ untouched.
+// Progress is published to opts.Progress and to SubscribeBuildEvents subscribers.
+func (e *Engine) BuildProjectContext(ctx context.Context, projectName string, opts BuildOptions) (*BuildReport, error) {
+	events := &buildEmitter{project: projectName, progress: opts.Progress, hub: e.events}
+	events.emit(EventBuildStarted, "", "")
+
+	report, err := e.buildProject(ctx, projectName, opts, events)
+	if err != nil {
+		events.emitError(EventBuildFinished, "", "build failed", err)
+		return nil, err
+	}
+
+	events.emit(EventBuildFinished, "", fmt.Sprintf("%d rebuilt, %d skipped, %d deleted",
+		report.Rebuilt, report.Skipped, report.Deleted))
+	return report, nil
+}
+
+// buildProject does the actual work of BuildProjectContext.
+func (e *Engine) buildProject(ctx context.Context, projectName string, opts BuildOptions, events *buildEmitter) (*BuildReport, error) {
 	project, err := e.FindProjectByName(projectName)
 	if err != nil {
-		return err // Project not found
+		return nil, err // Project not found
 	}
 
+	defer e.lockProject(project.Path)()
+
 	log.Printf("Starting build for project: %s", project.Name)
 
+	// The site configuration provides the defaults for everything the
+	// options of this build leave open.
+	config, err := loadSiteConfig(project.Path)
+	if err != nil {
+		return nil, err
+	}
+	opts = config.apply(opts)
+
 	// Define key paths
 	contentDir := filepath.Join(project.Path, "content")
 	publicDir := filepath.Join(project.Path, "public")
-	themeDir := filepath.Join(project.Path, "themes", "default") // Assuming 'default' theme for now
-	templatePath := filepath.Join(themeDir, "templates", "page.html")
+	if opts.preview {
+		publicDir = filepath.Join(project.Path, filepath.FromSlash(previewDir))
+	}
 
-	// 1. Clean the public directory
-	log.Println("Cleaning public directory...")
-	if err := os.RemoveAll(publicDir); err != nil {
-		return fmt.Errorf("failed to clean public directory: %w", err)
+	// 1. Parse the layouts and partials of the theme, its parents and the
+	// project's layouts/ once. Any change in the template set invalidates
+	// every rendered page.
+	theme, err := loadTheme(project.Path, config.Theme, opts)
+	if err != nil {
+		return nil, err
 	}
-	if err := os.MkdirAll(publicDir, 0755); err != nil {
-		return fmt.Errorf("failed to recreate public directory: %w", err)
+	templateHash, err := theme.hash()
+	if err != nil {
+		return nil, fmt.Errorf("could not hash theme templates: %w", err)
 	}
+	// The highlighting and heading options change the HTML of content as
+	// much as a template.
+	templateHash = fingerprint(templateHash, fmt.Sprintf("%+v", opts.markdownOptions()))
 
-	// 2. Parse the main page template once
-	tmpl, err := template.ParseFiles(templatePath)
+	workers := opts.Workers
+	if workers < 1 {
+		workers = defaultWorkers()
+	}
+
+	// 2. Load and parse all content up front: list pages need to know every
+	// page before any of them can be rendered.
+	log.Println("Loading content...")
+	content, err := loadContent(ctx, contentDir, workers, events, project.Path, publishedOnly(opts, time.Now(), events))
 	if err != nil {
-		return fmt.Errorf("could not parse page template '%s': %w", templatePath, err)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("build cancelled: %w", err)
+		}
+		return nil, fmt.Errorf("failed to load content:\n%w", err)
 	}
+	if err := content.applyPermalinks(config.Permalinks, config.PrettyURLs); err != nil {
+		return nil, err
+	}
+	taxonomies := opts.Taxonomies
+	if len(taxonomies) == 0 {
+		taxonomies = defaultTaxonomies
+	}
+	content.buildTaxonomies(taxonomies)
 
-	// 3. Process content files
-	log.Println("Processing content files...")
-	err = filepath.Walk(contentDir, func(path string, info os.FileInfo, err error) error {
+	siteTitle := config.Title
+	if siteTitle == "" {
+		siteTitle = content.home.Title
+	}
+	if siteTitle == "" {
+		siteTitle = project.Name
+	}
+	data, dataHash, err := loadData(project.Path)
+	if err != nil {
+		return nil, err
+	}
+	site := config.site(opts, siteTitle)
+	site.Data = data
+	if site.Menus, err = buildMenus(config.Menus, content); err != nil {
+		return nil, err
+	}
+	content.setSite(site)
+	content.setTheme(theme, opts.markdownOptions())
+	siteJSON, err := json.Marshal(site)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash site configuration: %w", err)
+	}
+
+	// 3. Render into a staging directory; public/ is only replaced once the
+	// whole build succeeded. Without a usable cache we cannot tell our own
+	// outputs from leftovers, so the staging directory starts out empty and
+	// the build is a full one, like a clean build always was.
+	cache := loadBuildCache(project.Path, opts.preview)
+	if cache.isEmpty() {
+		log.Println("No build cache found, doing a full build...")
+	}
+	stagingDir, err := prepareStaging(publicDir, !cache.isEmpty())
+	if err != nil {
+		return nil, err
+	}
+	// After a successful publish the staging directory no longer exists and this is a no-op.
+	defer releaseBuildDir(stagingDir)
+	defer os.RemoveAll(stagingDir)
+
+	state := &buildState{
+		ctx:         ctx,
+		projectPath: project.Path,
+		outputDir:   stagingDir,
+		cache:       cache,
+		outputs:     make(map[string]string),
+		owners:      make(map[string]string),
+		events:      events,
+
+		opts:         opts,
+		theme:        theme,
+		templateHash: templateHash,
+		site:         site,
+		siteHash:     hashBytes(siteJSON),
+		dataHash:     dataHash,
+		pagesHash:    hashPages(site.Pages),
+		buildID:      time.Now().Format(time.RFC3339Nano),
+	}
+
+	// 4. Plan every output and schedule work only for those whose inputs changed.
+	if err := planSite(state, content); err != nil {
+		return nil, err
+	}
+	if err := planStaticAssets(theme.staticLayers, state); err != nil {
+		return nil, fmt.Errorf("failed to copy static assets: %w", err)
+	}
+	// Aliases come after every real output, which they make way for.
+	if err := planAliases(state, content); err != nil {
+		return nil, err
+	}
+	// The sitemap lists what was planned above, and makes way for a
+	// robots.txt the project ships itself.
+	if err := planSitemap(state); err != nil {
+		return nil, err
+	}
+	if err := planHighlightStylesheet(state); err != nil {
+		return nil, err
+	}
+
+	// 5. Render and copy on the worker pool.
+	log.Printf("Processing %d outputs with %d workers...", len(state.jobs), workers)
+	if err := runJobs(ctx, state.jobs, workers); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("build cancelled: %w", err)
+		}
+		return nil, fmt.Errorf("failed to render content:\n%w", err)
+	}
+
+	// 6. Remove outputs that the previous build produced but this one did not.
+	for relPath := range state.cache.Outputs {
+		if _, ok := state.outputs[relPath]; ok {
+			continue
+		}
+		log.Printf("Removing stale output: %s", relPath)
+		if err := removeOutput(stagingDir, relPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale output %s: %w", relPath, err)
+		}
+		state.report.Deleted++
+	}
+
+	// 7. Swap the finished output into place, unless we were asked to stop.
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("build cancelled: %w", err)
+	}
+	log.Println("Publishing build output...")
+	if err := publishStaging(stagingDir, publicDir); err != nil {
+		return nil, err
+	}
+
+	// 8. Remember what we produced for the next build.
+	state.cache.Outputs = state.outputs
+	if err := state.cache.save(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Build finished: %d rebuilt, %d skipped, %d deleted",
+		state.report.Rebuilt, state.report.Skipped, state.report.Deleted)
+	return &state.report, nil
+}
+
+// sectionOf returns the top level directory of a content relative path.
+func sectionOf(relPath string) string {
+	parts := strings.SplitN(filepath.ToSlash(relPath), "/", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+// trackedJob wraps the work on a single output into a pool job that reports
+// its start, completion (as doneKind) or failure as build events.
+func (s *buildState) trackedJob(source string, doneKind BuildEventKind, work func() error) renderJob {
+	return renderJob{source: source, run: func() error {
+		s.events.emit(EventFileStarted, source, "")
+		if err := work(); err != nil {
+			s.events.emitError(EventError, source, "", err)
+			return err
+		}
+		s.events.emit(doneKind, source, "")
+		return nil
+	}}
+}
+
+// planSite schedules every regular page, every list page with its pagers,
+// every taxonomy page and every plain file found in content/.
+func planSite(state *buildState, content *siteContent) error {
+	// Regular pages depend on their own source, the templates and what
+	// templates may reach through .Parent and .Terms: the section with its
+	// _index.md and every page listed in it, and the pages of each term.
+	for _, page := range content.pages {
+		source := "content/" + page.File
+		candidates := singleLayoutCandidates(page.Section, layoutOf(page))
+		fp := fingerprint("page", page.sourceHash, state.templateHash, sectionFingerprint(page.Parent),
+			pageTermsFingerprint(page), state.siteHash, state.usesFingerprint(state.theme.uses(candidates)))
+		err := state.planPage(outputPathFor(page.RelPermalink), source, fp, func(destPath string) error {
+			return renderPage(destPath, state.theme, candidates, page)
+		})
 		if err != nil {
 			return err
 		}
+		state.sitemap = append(state.sitemap, page)
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	for _, file := range content.files {
+		if err := state.planCopy(file.relPath, file.sourcePath, EventFileDone); err != nil {
+			return err
 		}
+	}
 
-		// Calculate the relative path to preserve directory structure
-		relPath, err := filepath.Rel(contentDir, path)
+	if err := planFeeds(state, content); err != nil {
+		return err
+	}
+
+	// The error page only depends on the templates and the site settings.
+	if state.theme.hasAny([]string{notFoundLayout}) {
+		notFound := &Page{
+			Kind:         Kind404,
+			Title:        "Page not found",
+			FrontMatter:  make(map[string]interface{}),
+			RelPermalink: "/" + notFoundLayout + ".html",
+			Site:         state.site,
+		}
+		notFound.Permalink = absURL(state.site.BaseURL, notFound.RelPermalink)
+		fp := fingerprint("404", state.templateHash, state.siteHash,
+			state.usesFingerprint(state.theme.uses([]string{notFoundLayout})))
+		err := state.planPage(outputPathFor(notFound.RelPermalink), "404", fp, func(destPath string) error {
+			return renderPage(destPath, state.theme, []string{notFoundLayout}, notFound)
+		})
 		if err != nil {
 			return err
 		}
-		destPath := filepath.Join(publicDir, relPath)
+	}
 
-		// Ensure the destination directory exists
-		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+	// List pages depend on everything they list, so any change below a
+	// directory re-renders its list pages.
+	if !state.theme.hasListLayouts() {
+		state.events.emit(EventWarning, "", "theme has no list or index layout, skipping list pages")
+		return nil
+	}
+	for _, section := range content.sortedSections() {
+		source := "content/" + section.dir
+		if section.File != "" {
+			source = "content/" + section.File
+		}
+		candidates := listLayoutCandidates(section.Kind, section.Section, layoutOf(section))
+		pageSize := frontMatterInt(section.FrontMatter["paginate"])
+		if err := planList(state, section, source, candidates, pageSize, sectionFingerprint(section)); err != nil {
 			return err
 		}
+	}
 
-		// Process Markdown files
-		if strings.HasSuffix(info.Name(), ".md") {
-			destPath = strings.TrimSuffix(destPath, ".md") + ".html"
-			return processMarkdownFile(path, destPath, tmpl)
-		} else {
-			// Copy other files directly
-			return copyFile(path, destPath)
+	// Taxonomies get an overview page listing their terms, and every term a
+	// paginated list of the pages using it.
+	for _, taxonomy := range content.taxonomies {
+		source := "taxonomy " + taxonomy.Section
+		candidates := taxonomyLayoutCandidates(taxonomy.Kind, taxonomy.Section)
+		if err := planList(state, taxonomy, source, candidates, 0, termsFingerprint(taxonomy)); err != nil {
+			return err
 		}
-	})
-	if err != nil {
-		return fmt.Errorf("error walking content directory: %w", err)
+		for _, term := range taxonomy.Pages {
+			candidates := taxonomyLayoutCandidates(term.Kind, term.Section)
+			if err := planList(state, term, source, candidates, 0, sectionFingerprint(term)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planFeeds schedules the feeds of the home page, every section and every
+// term. They do not need any layout, so themes without list layouts get them too.
+func planFeeds(state *buildState, content *siteContent) error {
+	if state.opts.Feeds.Disable {
+		return nil
+	}
+	if state.opts.BaseURL == "" {
+		state.events.emit(EventWarning, "", "no base URL configured, feeds will contain relative links")
+	}
+
+	lists := content.sortedSections()
+	for _, taxonomy := range content.taxonomies {
+		lists = append(lists, taxonomy.Pages...)
 	}
+	for _, list := range lists {
+		list.feeds = feedLinks(state.opts.BaseURL, list)
 
-	// 4. Copy theme static assets
-	log.Println("Copying static assets...")
-	staticDir := filepath.Join(themeDir, "static")
-	return copyStaticAssets(staticDir, publicDir)
+		source := "content/" + list.dir
+		if list.File != "" {
+			source = "content/" + list.File
+		}
+		if list.Kind == KindTerm {
+			source = "taxonomy " + list.Section
+		}
+		// Feeds carry absolute links and either the summary or the full
+		// content, so the options are part of what they depend on, and so is
+		// everything the content is rendered with: shortcode templates, the
+		// Markdown options and, through shortcodes, the data files and pages.
+		fp := fingerprint("feed", sectionFingerprint(list), state.siteHash, state.templateHash,
+			fmt.Sprint(state.opts.Feeds.Limit, "/", state.opts.Feeds.FullContent), state.usesFingerprint(state.theme.shortcodeUses))
+		for _, link := range list.feeds {
+			link := link
+			f := newFeed(state.site.Title, list, state.opts.Feeds)
+			relPath := outputPathFor(link.RelPermalink)
+			_, err := state.planOutput(relPath, source, fp, func(destPath string) error {
+				outputFile, err := createOutputFile(destPath)
+				if err != nil {
+					return fmt.Errorf("failed to create output file %s: %w", destPath, err)
+				}
+				defer outputFile.Close()
+				if err := writeFeed(outputFile, path.Base(link.RelPermalink), link.Permalink, f); err != nil {
+					return fmt.Errorf("failed to write feed %s: %w", link.RelPermalink, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-// processMarkdownFile reads, parses, and renders a single markdown file using the provided template.
-func processMarkdownFile(sourcePath, destPath string, tmpl *template.Template) error {
-	log.Printf("Processing markdown file: %s", sourcePath)
-	fileData, err := os.ReadFile(sourcePath)
-	if err != nil {
-		return fmt.Errorf("failed to read file %s: %w", sourcePath, err)
+// planList schedules the pagers of a list page. pageSize overrides the build's
+// page size when positive; listHash fingerprints everything the list shows.
+func planList(state *buildState, list *Page, source string, candidates []string, pageSize int, listHash string) error {
+	if !state.theme.hasAny(candidates) {
+		state.events.emit(EventWarning, source, "no list layout found, skipping list page")
+		return nil
+	}
+
+	if pageSize < 1 {
+		pageSize = state.opts.PageSize
+	}
+	if pageSize < 1 {
+		pageSize = defaultPageSize
 	}
 
-	parts := strings.SplitN(string(fileData), "---", 3)
-	if len(parts) < 3 {
-		return fmt.Errorf("invalid front matter in file %s", sourcePath)
+	for _, pager := range paginate(list.regularPages, pageSize, list.RelPermalink) {
+		view := *list
+		view.Paginator = pager
+		fp := fingerprint("list", listHash, state.templateHash, fmt.Sprint(pageSize, "/", pager.PageNumber),
+			state.siteHash, fmt.Sprint(len(list.feeds)),
+			state.usesFingerprint(state.theme.uses(candidates)))
+		err := state.planPage(outputPathFor(pager.URL), source, fp, func(destPath string) error {
+			return renderPage(destPath, state.theme, candidates, &view)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// Only the first pager is worth listing in the sitemap.
+	state.sitemap = append(state.sitemap, list)
+	return nil
+}
+
+// sectionFingerprint hashes everything a list page shows: its own _index.md,
+// its parent's and every page and section below it.
+func sectionFingerprint(section *Page) string {
+	parts := []string{section.sourceHash}
+	if section.Parent != nil {
+		parts = append(parts, section.Parent.sourceHash)
+	}
+	for _, page := range section.regularPages {
+		parts = append(parts, page.File, page.sourceHash, page.RelPermalink)
 	}
+	for _, child := range section.Sections {
+		parts = append(parts, child.dir, child.sourceHash, fmt.Sprint(len(child.regularPages)))
+	}
+	return fingerprint(parts...)
+}
 
-	page := Page{
-		FrontMatter: make(map[string]interface{}),
+// pageTermsFingerprint hashes the term pages a page is classified with, and the
+// pages listed on them.
+func pageTermsFingerprint(page *Page) string {
+	var parts []string
+	for _, taxonomy := range sortedKeys(page.terms) {
+		for _, term := range page.terms[taxonomy] {
+			parts = append(parts, taxonomy, term.RelPermalink, sectionFingerprint(term))
+		}
 	}
+	return fingerprint(parts...)
+}
 
-	if err := yaml.Unmarshal([]byte(parts[1]), &page.FrontMatter); err != nil {
-		return fmt.Errorf("failed to parse front matter in %s: %w", sourcePath, err)
+// hashPages hashes what templates see of a list of pages without
+// rendering them: their files, sources and URLs.
+func hashPages(pages []*Page) string {
+	parts := make([]string, 0, 3*len(pages))
+	for _, page := range pages {
+		parts = append(parts, page.File, page.sourceHash, page.RelPermalink)
 	}
+	return fingerprint(parts...)
+}
 
-	extensions := parser.CommonExtensions | parser.AutoHeadingIDs
-	p := parser.NewWithExtensions(extensions)
-	htmlContent := markdown.ToHTML([]byte(parts[2]), p, nil)
-	page.Content = template.HTML(htmlContent)
+// layoutOf returns the layout a page asks for in its front matter, if any.
+func layoutOf(page *Page) string {
+	layoutName, _ := page.FrontMatter["layout"].(string)
+	return layoutName
+}
 
-	outputFile, err := os.Create(destPath)
+// outputPathFor maps a page URL to the file that serves it: "/posts/a.html"
+// is written as posts/a.html, "/posts/" as posts/index.html.
+func outputPathFor(url string) string {
+	relPath := strings.TrimPrefix(url, "/")
+	if relPath == "" || strings.HasSuffix(relPath, "/") {
+		relPath += "index.html"
+	}
+	return filepath.FromSlash(relPath)
+}
+
+// renderPage executes the first matching layout for a page into destPath.
+func renderPage(destPath string, theme *Theme, candidates []string, page *Page) error {
+	outputFile, err := createOutputFile(destPath)
 	if err != nil {
 		return fmt.Errorf("failed to create output file %s: %w", destPath, err)
 	}
 	defer outputFile.Close()
 
-	return tmpl.Execute(outputFile, page)
+	if err := theme.execute(outputFile, candidates, page); err != nil {
+		// A broken shortcode is best reported where it is written, not as
+		// the template call that rendered the content.
+		var tmplErr *TemplateError
+		var contentErr *contentError
+		switch {
+		case errors.As(err, &tmplErr):
+			return tmplErr
+		case errors.As(err, &contentErr):
+			return contentErr
+		case errors.As(theme.locateError(err), &tmplErr):
+			tmplErr.Page = page.RelPermalink
+			if page.File != "" {
+				tmplErr.Content = "content/" + page.File
+			}
+			return tmplErr
+		}
+		return fmt.Errorf("failed to render %s: %w", page.RelPermalink, err)
+	}
+	return nil
 }
 
-// copyStaticAssets recursively copies files from a source to a destination directory.
-func copyStaticAssets(src, dst string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel(src, path)
+// planStaticAssets schedules copying the static files of the project and its
+// theme chain into the output, skipping files that did not change since the
+// last build. layers are ordered by precedence: a file found in an earlier
+// layer hides the file of the same name in the later ones.
+func planStaticAssets(layers []themeLayer, state *buildState) error {
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		err := layer.walk(func(relPath string) error {
+			if seen[relPath] {
+				return nil
+			}
+			seen[relPath] = true
+			return state.planCopyFS(filepath.FromSlash(relPath), layer.fsys, relPath, layer.name+"/"+relPath, EventAssetCopied)
+		})
 		if err != nil {
 			return err
 		}
-		destPath := filepath.Join(dst, relPath)
-
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
-		return copyFile(path, destPath)
-	})
+	}
+	return nil
 }
 
 // copyFile is a simple utility to copy a single file.
@@ -155,7 +718,7 @@ func copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	destFile, err := createOutputFile(dst)
 	if err != nil {
 		return err
 	}
@@ -165,6 +728,54 @@ func copyFile(src, dst string) error {
 	return err
 }
 
+// copyFSFile copies a single file out of an fs.FS.
+func copyFSFile(fsys fs.FS, name, dst string) error {
+	sourceFile, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	destFile, err := createOutputFile(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, sourceFile)
+	return err
+}
+
+// createOutputFile creates a file for writing, along with its parent directories.
+// An existing file is unlinked first instead of being truncated: the staging
+// directory shares its files with public/ through hard links, and truncating
+// would rewrite the published copy too.
+func createOutputFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// removeOutput deletes a previously generated file and any directories that
+// became empty because of it, stopping at the output directory itself.
+func removeOutput(outputDir, relPath string) error {
+	fullPath := filepath.Join(outputDir, filepath.FromSlash(relPath))
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(fullPath); dir != outputDir && strings.HasPrefix(dir, outputDir); dir = filepath.Dir(dir) {
+		// os.Remove refuses to delete non-empty directories, which is exactly the stop condition.
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
 func (e *Engine) ListContentFiles(projectName string) ([]string, error) {
 	project, err := e.FindProjectByName(projectName)
 	if err != nil {
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestProject creates a project named "site" in a temporary directory,
+// with the given files written over the scaffolded ones.
+func newTestProject(t *testing.T, files map[string]string) (*Engine, string) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	engine, err := NewEngineWithConfig(filepath.Join(dir, "projects.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.AddProject("site", dir); err != nil {
+		t.Fatal(err)
+	}
+	projectPath := filepath.Join(dir, "site")
+	writeTestFiles(t, projectPath, files)
+	return engine, projectPath
+}
+
+// writeTestFiles writes files, keyed by their path relative to the project.
+func writeTestFiles(t *testing.T, projectPath string, files map[string]string) {
+	t.Helper()
+	for relPath, text := range files {
+		fullPath := filepath.Join(projectPath, filepath.FromSlash(relPath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// buildTestProject builds the project and returns its report.
+func buildTestProject(t *testing.T, engine *Engine) *BuildReport {
+	t.Helper()
+	report, err := engine.BuildProjectContext(context.Background(), "site", BuildOptions{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+// readOutput returns a file of the project's public/ directory.
+func readOutput(t *testing.T, projectPath, relPath string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(projectPath, "public", filepath.FromSlash(relPath)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	engine, projectPath := newTestProject(t, map[string]string{
+		"site.yaml":          "title: Test\nbaseURL: https://example.com/\nprettyURLs: true\n",
+		"layouts/page.html":  "v1 {{ .Title }}:{{ range .Parent.Pages }} {{ .Title }}{{ end }}; tags:{{ range .Terms \"tags\" }}{{ range .Pages }} {{ .Title }}{{ end }}{{ end }}\n",
+		"content/posts/a.md": "---\ntitle: Alpha\ndate: 2024-01-01\ntags: [go]\n---\nA\n",
+		"content/posts/b.md": "---\ntitle: Beta\ndate: 2024-01-02\n---\nB\n",
+		"content/notes/n.md": "---\ntitle: Note\ndate: 2024-01-03\ntags: [go]\n---\nN\n",
+	})
+	buildTestProject(t, engine)
+	if got, want := readOutput(t, projectPath, "posts/a/index.html"), "v1 Alpha: Beta Alpha; tags: Note Alpha\n"; got != want {
+		t.Fatalf("first build wrote %q, want %q", got, want)
+	}
+
+	t.Run("unchanged", func(t *testing.T) {
+		if report := buildTestProject(t, engine); report.Rebuilt != 0 {
+			t.Errorf("rebuilt %d pages of an unchanged project", report.Rebuilt)
+		}
+	})
+
+	t.Run("added sibling", func(t *testing.T) {
+		writeTestFiles(t, projectPath, map[string]string{
+			"content/posts/d.md": "---\ntitle: Delta\ndate: 2024-01-04\n---\nD\n",
+		})
+		buildTestProject(t, engine)
+		if got, want := readOutput(t, projectPath, "posts/a/index.html"), "v1 Alpha: Delta Beta Alpha; tags: Note Alpha\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("edited sibling", func(t *testing.T) {
+		writeTestFiles(t, projectPath, map[string]string{
+			"content/posts/b.md": "---\ntitle: Bravo\ndate: 2024-01-02\n---\nB\n",
+		})
+		buildTestProject(t, engine)
+		if got, want := readOutput(t, projectPath, "posts/a/index.html"), "v1 Alpha: Delta Bravo Alpha; tags: Note Alpha\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("edited page of a term", func(t *testing.T) {
+		writeTestFiles(t, projectPath, map[string]string{
+			"content/notes/n.md": "---\ntitle: Memo\ndate: 2024-01-03\ntags: [go]\n---\nN\n",
+		})
+		buildTestProject(t, engine)
+		if got, want := readOutput(t, projectPath, "posts/a/index.html"), "v1 Alpha: Delta Bravo Alpha; tags: Memo Alpha\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("changed template", func(t *testing.T) {
+		writeTestFiles(t, projectPath, map[string]string{
+			"layouts/page.html": "v2 {{ .Title }}\n",
+		})
+		buildTestProject(t, engine)
+		for relPath, want := range map[string]string{
+			"posts/a/index.html": "v2 Alpha\n",
+			"posts/b/index.html": "v2 Bravo\n",
+			"notes/n/index.html": "v2 Memo\n",
+		} {
+			if got := readOutput(t, projectPath, relPath); got != want {
+				t.Errorf("%s is %q, want %q", relPath, got, want)
+			}
+		}
+	})
+}
+
+func TestIncrementalBuildNow(t *testing.T) {
+	engine, projectPath := newTestProject(t, map[string]string{
+		"site.yaml":          "title: Test\nbaseURL: https://example.com/\nprettyURLs: true\n",
+		"layouts/page.html":  "{{ .Title }} {{ now.UnixNano }}\n",
+		"content/posts/a.md": "---\ntitle: Alpha\n---\nA\n",
+	})
+	buildTestProject(t, engine)
+	first := readOutput(t, projectPath, "posts/a/index.html")
+	buildTestProject(t, engine)
+	if second := readOutput(t, projectPath, "posts/a/index.html"); second == first {
+		t.Errorf("a page using now was not rendered again: %q", strings.TrimSpace(second))
+	}
+}
+
+func TestIncrementalBuildSitePages(t *testing.T) {
+	engine, projectPath := newTestProject(t, map[string]string{
+		"site.yaml":               "title: Test\nbaseURL: https://example.com/\nprettyURLs: true\n",
+		"layouts/notes/page.html": "{{ with .Site }}{{ len .Pages }}{{ end }}; {{ $site := .Site }}{{ len $site.Pages }}\n",
+		"content/notes/n.md":      "---\ntitle: Note\ndate: 2024-01-01\n---\nN\n",
+	})
+	buildTestProject(t, engine)
+
+	writeTestFiles(t, projectPath, map[string]string{
+		"content/posts/a.md": "---\ntitle: Alpha\ndate: 2024-01-02\n---\nA\n",
+	})
+	buildTestProject(t, engine)
+	// The site's pages are the two regular pages, the home page, both
+	// sections and the two taxonomy overviews.
+	if got, want := readOutput(t, projectPath, "notes/n/index.html"), "7; 7\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
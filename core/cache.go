@@ -0,0 +1,161 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// buildCacheVersion is bumped whenever the fingerprint scheme changes, so that
+// caches written by an older version force a full rebuild instead of being trusted.
+const buildCacheVersion = 3
+
+// buildCache remembers, for every file written to public/, a fingerprint of the
+// inputs that produced it. A later build only re-renders an output when its
+// fingerprint changes, and deletes outputs that are no longer produced at all.
+type buildCache struct {
+	Version int               `json:"version"`
+	Outputs map[string]string `json:"outputs"` // Output path relative to public/ -> input fingerprint.
+	path    string            // Where the cache lives on disk, not saved in the JSON itself.
+}
+
+// buildCachePath returns the location of the build cache inside a project.
+// Previews are built into a directory of their own and so keep a cache of
+// their own too.
+func buildCachePath(projectPath string, preview bool) string {
+	if preview {
+		return filepath.Join(projectPath, ".gossg", "preview-cache.json")
+	}
+	return filepath.Join(projectPath, ".gossg", "build-cache.json")
+}
+
+// loadBuildCache reads the project's build cache. A missing, unreadable or
+// outdated cache is not an error; it simply yields an empty cache, which makes
+// the next build a full one.
+func loadBuildCache(projectPath string, preview bool) *buildCache {
+	cache := &buildCache{
+		Version: buildCacheVersion,
+		Outputs: make(map[string]string),
+		path:    buildCachePath(projectPath, preview),
+	}
+
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+
+	var stored buildCache
+	if err := json.Unmarshal(data, &stored); err != nil || stored.Version != buildCacheVersion || stored.Outputs == nil {
+		return cache
+	}
+	cache.Outputs = stored.Outputs
+	return cache
+}
+
+// isEmpty reports whether the cache knows nothing about previous builds.
+func (c *buildCache) isEmpty() bool {
+	return len(c.Outputs) == 0
+}
+
+// upToDate reports whether the output at relPath was produced from inputs with
+// the given fingerprint and still exists inside outputDir.
+func (c *buildCache) upToDate(outputDir, relPath, fingerprint string) bool {
+	if c.Outputs[relPath] != fingerprint {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(outputDir, relPath))
+	return err == nil
+}
+
+// save writes the cache back to disk.
+func (c *buildCache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal build cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create build cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write build cache: %w", err)
+	}
+	return nil
+}
+
+// fingerprint combines any number of strings into a single stable hash.
+func fingerprint(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		// The length prefix keeps ("ab", "c") and ("a", "bc") apart.
+		fmt.Fprintf(h, "%d:%s;", len(part), part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashBytes returns the hex encoded SHA-256 of data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile returns the hex encoded SHA-256 of a file's content.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// hashFSFile is hashFile for a file of an fs.FS, such as the built-in theme.
+func hashFSFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFS hashes every file of fsys, including their paths, so that adding,
+// removing, renaming or editing any file changes the result. A file system
+// rooted at a missing directory hashes to a fixed value.
+func hashFS(fsys fs.FS) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == "." {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		fileHash, err := hashFSFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s=%s\n", path, fileHash)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,552 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// Page kinds. Every Markdown file is a regular page; every content directory
+// (and the content root itself, the home page) becomes a list page. Each
+// taxonomy gets an overview page and each of its terms a list page.
+const (
+	KindPage     = "page"
+	KindSection  = "section"
+	KindHome     = "home"
+	KindTaxonomy = "taxonomy"
+	KindTerm     = "term"
+	Kind404      = "404"
+)
+
+// sectionIndexFile holds the title, front matter and text of a list page itself.
+const sectionIndexFile = "_index.md"
+
+// Page holds the data for a single rendered page.
+type Page struct {
+	Kind    string // One of the Kind constants.
+	Title   string
+	Date    time.Time
+	Lastmod time.Time // The `lastmod` front matter date, or Date when there is none.
+	Author  string
+	Slug    string   // The `slug` front matter, which replaces the file name in the page's URL.
+	Aliases []string // Old URLs of the page that redirect to it, from the `aliases` front matter.
+	Weight  int
+
+	// Draft, PublishDate and ExpiryDate decide whether a build includes the
+	// page. PublishDate is the `publishDate` front matter date, or Date.
+	Draft       bool
+	PublishDate time.Time
+	ExpiryDate  time.Time
+
+	FrontMatter map[string]interface{}
+	Section     string // The top level content directory the page lives in, empty for the root.
+
+	// File is the source path relative to content/, slash separated.
+	// It is empty for list pages without an _index.md.
+	File string
+
+	// RelPermalink is the URL path of the page, e.g. "/posts/hello.html" or
+	// "/posts/"; see permalinks.go for how site.yaml changes it.
+	// Permalink is the same URL made absolute with the site's base URL; it
+	// equals RelPermalink while no base URL is configured.
+	RelPermalink string
+	Permalink    string
+
+	Site      *Site   // The site wide settings from site.yaml.
+	Parent    *Page   // The section (or taxonomy) the page belongs to; nil for the home page.
+	Pages     []*Page // For list pages: the regular pages directly inside the directory, sorted. For taxonomies: the terms.
+	Sections  []*Page // For list pages: the list pages of the directories directly inside.
+	Paginator *Pager  // For list pages: the current page of RegularPagesRecursive.
+
+	dir          string // Content directory of the page, slash separated, "" for the root.
+	body         []byte
+	bodyLine     int // The line of the source file the body starts on.
+	sourceHash   string
+	modTime      time.Time // Modification time of the source file.
+	content      *lazyContent
+	regularPages []*Page            // Every regular page below a list page, sorted.
+	terms        map[string][]*Page // Term pages the page is classified with, by taxonomy.
+	feeds        []FeedLink         // The feeds of a list page, if feeds are enabled.
+}
+
+// Content returns the page's Markdown rendered to HTML. Rendering happens on
+// first use, so pages that are neither written nor listed cost nothing. The
+// error reports a broken shortcode with the file and line it is on.
+func (p *Page) Content() (template.HTML, error) {
+	rendered, err := p.content.get()
+	return rendered.html, err
+}
+
+// TableOfContents returns nested lists linking to the headings of the page's
+// content, between the levels site.yaml sets, or nothing if there are none.
+func (p *Page) TableOfContents() (template.HTML, error) {
+	rendered, err := p.content.get()
+	return rendered.toc, err
+}
+
+// Headings returns the headings of the page's content as a tree: the top
+// level headings with the others nested in them.
+func (p *Page) Headings() ([]*Heading, error) {
+	rendered, err := p.content.get()
+	return rendered.headings, err
+}
+
+// RegularPagesRecursive returns every regular page below a list page,
+// including those in nested directories, sorted like Pages.
+func (p *Page) RegularPagesRecursive() []*Page {
+	return p.regularPages
+}
+
+// IsHome reports whether the page is the site's home page.
+func (p *Page) IsHome() bool { return p.Kind == KindHome }
+
+// IsSection reports whether the page lists a content directory.
+func (p *Page) IsSection() bool { return p.Kind == KindSection }
+
+// IsPage reports whether the page is a regular page rendered from a Markdown file.
+func (p *Page) IsPage() bool { return p.Kind == KindPage }
+
+// IsTerm reports whether the page lists the pages of one taxonomy term.
+func (p *Page) IsTerm() bool { return p.Kind == KindTerm }
+
+// IsTaxonomy reports whether the page is the overview of a taxonomy's terms.
+func (p *Page) IsTaxonomy() bool { return p.Kind == KindTaxonomy }
+
+// renderedContent is a page's Markdown converted to HTML, with what the
+// conversion found out about it.
+type renderedContent struct {
+	html      template.HTML
+	headings  []*Heading
+	toc       template.HTML
+	summary   template.HTML
+	truncated bool
+	plain     string
+	wordCount int
+}
+
+// lazyContent renders content once, on first use, and is safe for concurrent
+// use by the render workers. Pages hold it by pointer, so copies share the result.
+type lazyContent struct {
+	once     sync.Once
+	rendered renderedContent
+	err      error
+	render   func() (renderedContent, error)
+}
+
+func (l *lazyContent) get() (renderedContent, error) {
+	if l == nil {
+		return renderedContent{}, nil
+	}
+	l.once.Do(func() {
+		if l.render != nil {
+			l.rendered, l.err = l.render()
+		}
+	})
+	return l.rendered, l.err
+}
+
+// markdownOptions are the build options rendering content depends on.
+type markdownOptions struct {
+	highlight     HighlightOptions
+	headings      HeadingOptions
+	summaryLength int
+}
+
+func (opts BuildOptions) markdownOptions() markdownOptions {
+	return markdownOptions{highlight: opts.Highlight, headings: opts.Headings, summaryLength: opts.SummaryLength}
+}
+
+// contentFile is a non-Markdown file in content/ that is copied as it is.
+type contentFile struct {
+	sourcePath string
+	relPath    string
+}
+
+// siteContent is everything found in a project's content directory.
+type siteContent struct {
+	home       *Page
+	pages      []*Page          // Regular pages in path order.
+	sections   map[string]*Page // List pages keyed by directory, "" being the home page.
+	taxonomies []*Page          // Taxonomy overview pages, their terms in Pages.
+	files      []contentFile
+}
+
+// sortedSections returns the list pages in directory order.
+func (c *siteContent) sortedSections() []*Page {
+	dirs := make([]string, 0, len(c.sections))
+	for dir := range c.sections {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	sections := make([]*Page, len(dirs))
+	for i, dir := range dirs {
+		sections[i] = c.sections[dir]
+	}
+	return sections
+}
+
+// loadContent reads and parses every Markdown file below contentDir on the
+// worker pool and links the pages into a tree of sections. Like rendering,
+// parsing reports every broken file at once instead of stopping at the first.
+// Regular pages for which include returns false are left out entirely.
+func loadContent(ctx context.Context, contentDir string, workers int, events *buildEmitter, projectPath string, include func(*Page) bool) (*siteContent, error) {
+	content := &siteContent{sections: make(map[string]*Page)}
+
+	// 1. Find the files. The walk is lexical, which keeps everything below deterministic.
+	var markdownFiles []string
+	err := filepath.Walk(contentDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(contentDir, path)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(info.Name(), ".md") {
+			markdownFiles = append(markdownFiles, relPath)
+		} else {
+			content.files = append(content.files, contentFile{sourcePath: path, relPath: relPath})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Parse them in parallel.
+	parsed := make([]*Page, len(markdownFiles))
+	jobs := make([]renderJob, len(markdownFiles))
+	for i, relPath := range markdownFiles {
+		sourcePath := filepath.Join(contentDir, relPath)
+		jobs[i] = renderJob{source: sourcePath, run: func() error {
+			page, err := loadPage(sourcePath, filepath.ToSlash(relPath))
+			if err != nil {
+				if display, relErr := filepath.Rel(projectPath, sourcePath); relErr == nil {
+					events.emitError(EventError, filepath.ToSlash(display), "", err)
+				}
+				return err
+			}
+			parsed[i] = page
+			return nil
+		}}
+	}
+	if err := runJobs(ctx, jobs, workers); err != nil {
+		return nil, err
+	}
+
+	// 3. Every directory holding content is a section, and so is every directory above it.
+	content.home = content.section("")
+	for _, page := range parsed {
+		if path.Base(page.File) == sectionIndexFile {
+			content.section(page.dir).adoptIndex(page)
+			continue
+		}
+		if !include(page) {
+			continue
+		}
+		parent := content.section(page.dir)
+		page.Parent = parent
+		parent.Pages = append(parent.Pages, page)
+		content.pages = append(content.pages, page)
+	}
+
+	// 4. Link sections to their parents and collect the pages below each one.
+	for _, section := range content.sortedSections() {
+		if section.Kind == KindHome {
+			continue
+		}
+		parent := content.sections[parentDir(section.dir)]
+		section.Parent = parent
+		parent.Sections = append(parent.Sections, section)
+	}
+	for _, page := range content.pages {
+		for section := page.Parent; section != nil; section = section.Parent {
+			section.regularPages = append(section.regularPages, page)
+		}
+	}
+	for _, section := range content.sections {
+		sortPages(section.Pages)
+		sortPages(section.Sections)
+		sortPages(section.regularPages)
+	}
+
+	return content, nil
+}
+
+// allPages returns every page that gets rendered: regular pages, list pages,
+// taxonomy overviews and terms.
+func (c *siteContent) allPages() []*Page {
+	pages := append([]*Page{}, c.pages...)
+	pages = append(pages, c.sortedSections()...)
+	for _, taxonomy := range c.taxonomies {
+		pages = append(pages, taxonomy)
+		pages = append(pages, taxonomy.Pages...)
+	}
+	return pages
+}
+
+// setSite links every page to the site and makes its Permalink absolute.
+// Pages without an author of their own get the site's default author. The
+// site gets the lists of its pages.
+func (c *siteContent) setSite(site *Site) {
+	site.RegularPages = c.home.regularPages
+	site.Pages = append([]*Page{}, c.home.regularPages...)
+	site.Pages = append(site.Pages, c.sortedSections()...)
+	for _, taxonomy := range c.taxonomies {
+		site.Pages = append(site.Pages, taxonomy)
+		site.Pages = append(site.Pages, taxonomy.Pages...)
+	}
+	for _, page := range c.allPages() {
+		page.Site = site
+		page.Permalink = absURL(site.BaseURL, page.RelPermalink)
+		if page.Author == "" && page.Kind == KindPage {
+			page.Author = site.Author
+		}
+	}
+}
+
+// setTheme lets every page with a Markdown body render it, expanding its
+// shortcodes with the templates of theme.
+func (c *siteContent) setTheme(theme *Theme, opts markdownOptions) {
+	for _, page := range c.allPages() {
+		if page.File == "" {
+			continue
+		}
+		page.content = &lazyContent{render: func() (renderedContent, error) {
+			return renderContent(page, theme, opts)
+		}}
+	}
+}
+
+// absURL joins a base URL like "https://example.com/blog/" and a URL path.
+// Without a base URL the path is returned unchanged.
+func absURL(baseURL, urlPath string) string {
+	if baseURL == "" {
+		return urlPath
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(urlPath, "/")
+}
+
+// section returns the list page of a content directory, creating it and all
+// the list pages above it on first use.
+func (c *siteContent) section(dir string) *Page {
+	if section, ok := c.sections[dir]; ok {
+		return section
+	}
+
+	section := &Page{
+		Kind:         KindSection,
+		Title:        humanize(path.Base(dir)),
+		FrontMatter:  make(map[string]interface{}),
+		Section:      sectionOf(dir + "/"),
+		RelPermalink: "/" + dir + "/",
+		dir:          dir,
+	}
+	if dir == "" {
+		section.Kind = KindHome
+		section.Title = ""
+		section.RelPermalink = "/"
+	}
+	c.sections[dir] = section
+
+	if dir != "" {
+		c.section(parentDir(dir))
+	}
+	return section
+}
+
+// adoptIndex takes over the front matter and text of a directory's _index.md.
+func (p *Page) adoptIndex(index *Page) {
+	p.File = index.File
+	p.FrontMatter = index.FrontMatter
+	p.Date = index.Date
+	p.Lastmod = index.Lastmod
+	p.Author = index.Author
+	p.Weight = index.Weight
+	p.body = index.body
+	p.bodyLine = index.bodyLine
+	p.sourceHash = index.sourceHash
+	p.modTime = index.modTime
+	p.content = index.content
+	if index.Title != "" {
+		p.Title = index.Title
+	}
+}
+
+// loadPage reads a Markdown file and parses its front matter. The Markdown
+// itself is converted once setTheme made the shortcodes known, and only when
+// the page's Content is first used.
+func loadPage(sourcePath, relPath string) (*Page, error) {
+	fileData, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", sourcePath, err)
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file %s: %w", sourcePath, err)
+	}
+
+	frontMatter, body, err := splitFrontMatter(fileData)
+	if err != nil {
+		return nil, fmt.Errorf("%w in file %s", err, sourcePath)
+	}
+
+	page := &Page{
+		Kind:         KindPage,
+		FrontMatter:  make(map[string]interface{}),
+		Section:      sectionOf(relPath),
+		File:         relPath,
+		RelPermalink: "/" + strings.TrimSuffix(relPath, ".md") + ".html",
+		dir:          parentDir(relPath),
+		body:         body,
+		bodyLine:     1 + strings.Count(string(fileData[:len(fileData)-len(body)]), "\n"),
+		sourceHash:   hashBytes(fileData),
+		modTime:      info.ModTime(),
+	}
+	if err := yaml.Unmarshal(frontMatter, &page.FrontMatter); err != nil {
+		return nil, fmt.Errorf("failed to parse front matter in %s: %w", sourcePath, err)
+	}
+
+	page.Title, _ = page.FrontMatter["title"].(string)
+	page.Date = frontMatterTime(page.FrontMatter["date"])
+	page.Lastmod = frontMatterTime(page.FrontMatter["lastmod"])
+	if page.Lastmod.IsZero() {
+		page.Lastmod = page.Date
+	}
+	page.Author, _ = page.FrontMatter["author"].(string)
+	page.Slug, _ = page.FrontMatter["slug"].(string)
+	page.Aliases = frontMatterStrings(page.FrontMatter["aliases"])
+	page.Draft, _ = page.FrontMatter["draft"].(bool)
+	page.PublishDate = frontMatterTime(page.FrontMatter["publishDate"])
+	if page.PublishDate.IsZero() {
+		page.PublishDate = page.Date
+	}
+	page.ExpiryDate = frontMatterTime(page.FrontMatter["expiryDate"])
+	page.Weight = frontMatterInt(page.FrontMatter["weight"])
+	return page, nil
+}
+
+// splitFrontMatter separates the YAML front matter between the first two
+// `---` lines from the Markdown body.
+func splitFrontMatter(data []byte) (frontMatter, body []byte, err error) {
+	parts := strings.SplitN(string(data), "---", 3)
+	if len(parts) < 3 {
+		return nil, nil, fmt.Errorf("invalid front matter")
+	}
+	return []byte(parts[1]), []byte(parts[2]), nil
+}
+
+// renderMarkdown converts a Markdown body to HTML, highlighting its code
+// blocks unless the options disable it, and lists its headings. headingText,
+// if not nil, maps the text of headings to what readers will see, resolving
+// shortcode placeholders; the ids and titles of headings are made from that.
+func renderMarkdown(body []byte, opts markdownOptions, headingText func(string) string) (template.HTML, []*Heading, error) {
+	// Parsers and renderers keep state, so every conversion needs its own.
+	extensions := parser.CommonExtensions | parser.AutoHeadingIDs
+	doc := markdown.Parse(body, parser.NewWithExtensions(extensions))
+	if headingText != nil {
+		retitleHeadings(doc, headingText)
+	}
+
+	var err error
+	highlight := codeBlockHook(opts.highlight, &err)
+	renderer := html.NewRenderer(html.RendererOptions{
+		Flags: html.CommonFlags,
+		RenderNodeHook: func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+			if opts.headings.Anchors {
+				writeHeadingAnchor(w, node, entering)
+			}
+			return highlight(w, node, entering)
+		},
+	})
+	out := markdown.Render(doc, renderer)
+	return template.HTML(out), collectHeadings(doc, headingText), err
+}
+
+// sortPages orders pages the way lists show them: by weight (unweighted
+// pages last), then newest first, then by title and finally by file name.
+func sortPages(pages []*Page) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if a.Weight != b.Weight {
+			if a.Weight == 0 || b.Weight == 0 {
+				return b.Weight == 0
+			}
+			return a.Weight < b.Weight
+		}
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.File < b.File
+	})
+}
+
+// frontMatterTime interprets a front matter value as a point in time. YAML
+// timestamps arrive as time.Time already; quoted dates are parsed here.
+func frontMatterTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// frontMatterInt interprets a front matter value as an integer.
+func frontMatterInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// parentDir returns the slash separated directory of a content path, "" for the root.
+func parentDir(relPath string) string {
+	dir := path.Dir(relPath)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// humanize turns a directory name like "release-notes" into "Release notes".
+func humanize(name string) string {
+	name = strings.NewReplacer("-", " ", "_", " ").Replace(name)
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
@@ -0,0 +1,229 @@
+package core
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template/parse"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// The files in a project's data/ directory are what templates see as
+// .Site.Data. Directories and file names, without their extension, make up
+// the tree, so data/team/members.yaml is .Site.Data.team.members:
+//
+//	data/nav.yaml            {{ range .Site.Data.nav.main }}...{{ end }}
+//	data/team/members.json   {{ range .Site.Data.team.members }}...{{ end }}
+//	data/pricing.csv         {{ range .Site.Data.pricing }}{{ index . 0 }}{{ end }}
+//
+// YAML, JSON and TOML files hold any structure; CSV files are lists of rows,
+// each a list of fields. Other files are ignored.
+const dataDir = "data"
+
+// loadData reads every data file of a project into a tree and returns it with
+// a fingerprint of the files. A project without data/ has no data.
+func loadData(projectPath string) (map[string]interface{}, string, error) {
+	data := make(map[string]interface{})
+	files := make(map[string]string) // Which file each key was read from, to report clashes.
+	var parts []string
+
+	layer := themeLayer{name: dataDir, fsys: os.DirFS(filepath.Join(projectPath, dataDir))}
+	err := layer.walk(func(relPath string) error {
+		ext := path.Ext(relPath)
+		if strings.HasPrefix(path.Base(relPath), ".") || !isDataFile(ext) {
+			return nil
+		}
+		content, err := fs.ReadFile(layer.fsys, relPath)
+		if err != nil {
+			return err
+		}
+		value, err := decodeDataFile(ext, content)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s/%s: %w", dataDir, relPath, err)
+		}
+		if err := insertData(data, files, dataDir+"/"+relPath, strings.TrimSuffix(relPath, ext), value); err != nil {
+			return err
+		}
+		parts = append(parts, relPath, hashBytes(content))
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fingerprint(parts...), nil
+}
+
+// isDataFile reports whether files with an extension are data files.
+func isDataFile(ext string) bool {
+	switch ext {
+	case ".yaml", ".yml", ".json", ".toml", ".csv":
+		return true
+	}
+	return false
+}
+
+// decodeDataFile decodes the content of a data file according to its extension.
+func decodeDataFile(ext string, content []byte) (interface{}, error) {
+	var value interface{}
+	switch ext {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &value); err != nil {
+			return nil, err
+		}
+	case ".json":
+		if err := json.Unmarshal(content, &value); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		table := make(map[string]interface{})
+		if _, err := toml.Decode(string(content), &table); err != nil {
+			return nil, err
+		}
+		value = table
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(content))
+		reader.FieldsPerRecord = -1 // Rows may have as many fields as they need.
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		value = records
+	}
+	return value, nil
+}
+
+// insertData puts the value of a data file into the tree at the key path
+// derived from its name, e.g. "team/members". A name used by two files, or by
+// a file and a directory, is an error rather than one silently hiding the other.
+func insertData(data map[string]interface{}, files map[string]string, file, keyPath string, value interface{}) error {
+	keys := strings.Split(keyPath, "/")
+	node := data
+	for i, key := range keys[:len(keys)-1] {
+		dir := strings.Join(keys[:i+1], "/")
+		if other, ok := files[dir]; ok {
+			return dataClash(other, file, dir)
+		}
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[key] = child
+		}
+		node = child
+	}
+
+	key := keys[len(keys)-1]
+	if _, taken := node[key]; taken {
+		other, ok := files[keyPath]
+		if !ok {
+			other = dataDir + "/" + keyPath + "/"
+		}
+		return dataClash(other, file, keyPath)
+	}
+	node[key] = value
+	files[keyPath] = file
+	return nil
+}
+
+// dataClash reports two data files, or a file and a directory, with the same name.
+func dataClash(a, b, keyPath string) error {
+	return fmt.Errorf("%s and %s both define .Site.Data.%s", a, b, strings.ReplaceAll(keyPath, "/", "."))
+}
+
+// templatesUseData reports whether any template of a set refers to .Data.
+// It errs on the side of yes: any field of that name counts, as in
+// .Site.Data or $.Site.Data.
+func templatesUseData(set *template.Template) bool {
+	return templatesRefer(set, func(idents []string) bool {
+		return slices.Contains(idents, "Data")
+	})
+}
+
+// templatesUseSitePages reports whether any template of a set may refer to
+// .Site.Pages or .Site.RegularPages. What a field chain starts from is not
+// always known, as in {{ with .Site }}{{ .Pages }}{{ end }} or
+// {{ $site := .Site }}{{ $site.Pages }}, so any field named Pages counts.
+func templatesUseSitePages(set *template.Template) bool {
+	return templatesRefer(set, func(idents []string) bool {
+		return slices.Contains(idents, "Pages") || slices.Contains(idents, "RegularPages")
+	})
+}
+
+// templatesUseNow reports whether any template of a set calls the now
+// function, whose result differs on every build.
+func templatesUseNow(set *template.Template) bool {
+	return templatesRefer(set, func(idents []string) bool {
+		return len(idents) == 1 && idents[0] == "now"
+	})
+}
+
+// templatesRefer reports whether any template of a set refers to a field
+// chain, like .Site.Data, or calls a function that match accepts.
+func templatesRefer(set *template.Template, match func(idents []string) bool) bool {
+	for _, tmpl := range set.Templates() {
+		if tmpl.Tree != nil && refersTo(tmpl.Tree.Root, match) {
+			return true
+		}
+	}
+	return false
+}
+
+// refersTo reports whether a template tree has a field chain that match
+// accepts, given the chain's identifiers, e.g. ["Site", "Data"] for .Site.Data,
+// or a function call, given the function's name alone, e.g. ["now"].
+func refersTo(node parse.Node, match func(idents []string) bool) bool {
+	switch n := node.(type) {
+	case *parse.IdentifierNode:
+		return match([]string{n.Ident})
+	case *parse.FieldNode:
+		return match(n.Ident)
+	case *parse.VariableNode:
+		return match(n.Ident)
+	case *parse.ChainNode:
+		return match(n.Field) || refersTo(n.Node, match)
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if refersTo(child, match) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return refersTo(n.Pipe, match)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if refersTo(cmd, match) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if refersTo(arg, match) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return refersTo(n.Pipe, match) || refersTo(n.List, match) || refersTo(n.ElseList, match)
+	case *parse.RangeNode:
+		return refersTo(n.Pipe, match) || refersTo(n.List, match) || refersTo(n.ElseList, match)
+	case *parse.WithNode:
+		return refersTo(n.Pipe, match) || refersTo(n.List, match) || refersTo(n.ElseList, match)
+	case *parse.TemplateNode:
+		return refersTo(n.Pipe, match)
+	}
+	return false
+}
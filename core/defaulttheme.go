@@ -0,0 +1,55 @@
+package core
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// defaultThemeName is the theme projects use unless their site.yaml names another.
+const defaultThemeName = "default"
+
+// builtinThemeFiles is a small but complete theme: a home page, list, single,
+// taxonomy and 404 layouts and a stylesheet. New projects get a copy to adapt;
+// a project without a themes/default of its own is built with it directly.
+//
+//go:embed all:defaulttheme
+var builtinThemeFiles embed.FS
+
+// builtinTheme returns the built-in theme, laid out like a theme directory.
+func builtinTheme() fs.FS {
+	sub, err := fs.Sub(builtinThemeFiles, "defaulttheme")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// writeBuiltinTheme copies the built-in theme into themeDir. Files that
+// already exist are left alone, so an adapted theme is never overwritten.
+func writeBuiltinTheme(themeDir string) error {
+	theme := builtinTheme()
+	return fs.WalkDir(theme, ".", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(themeDir, filepath.FromSlash(path))
+		if entry.IsDir() {
+			return os.MkdirAll(destPath, 0755)
+		}
+		if _, err := os.Stat(destPath); err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		data, err := fs.ReadFile(theme, path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(destPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", destPath, err)
+		}
+		return nil
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
 // Engine is the central struct that manages all core functionality.
@@ -11,6 +12,10 @@ import (
 // The UI layer (Wails) will hold an instance of this Engine.
 type Engine struct {
 	config *Config
+	events *eventHub // Fans build progress out to subscribers such as the UI.
+
+	buildsMu sync.Mutex
+	builds   map[string]*sync.Mutex // One per project path, held for the whole of a build.
 }
 
 // NewEngine creates and initializes a new Engine instance.
@@ -25,10 +30,20 @@ func NewEngine() (*Engine, error) {
 	}
 
 	// Define the path for our application's configuration file.
-	configPath := filepath.Join(userConfigDir, "GoStaticCMS", "projects.json")
+	return NewEngineWithConfig(DefaultConfigPath(userConfigDir))
+}
+
+// DefaultConfigPath returns where projects.json lives below a user config directory.
+func DefaultConfigPath(userConfigDir string) string {
+	return filepath.Join(userConfigDir, "GoStaticCMS", "projects.json")
+}
 
-	// Load the configuration. The loadConfig function (which we will move
-	// to project.go) will handle creating the file if it doesn't exist.
+// NewEngineWithConfig creates an Engine backed by the projects.json at configPath
+// instead of the one in the user's config directory. Headless tools use it to
+// point at a project list of their own, e.g. in CI.
+func NewEngineWithConfig(configPath string) (*Engine, error) {
+	// Load the configuration. The loadConfig function will handle
+	// the file not existing yet.
 	config, err := loadConfig(configPath)
 	if err != nil {
 		log.Printf("Error loading configuration: %v", err)
@@ -40,9 +55,28 @@ func NewEngine() (*Engine, error) {
 	// Return a new Engine instance containing the loaded config.
 	return &Engine{
 		config: config,
+		events: newEventHub(),
+		builds: make(map[string]*sync.Mutex),
 	}, nil
 }
 
+// lockProject waits until no other build of the project at projectPath is
+// running and returns the function that lets the next one in. Builds of the
+// same project share its staging directories, build cache and public/, so
+// they must not overlap.
+func (e *Engine) lockProject(projectPath string) (unlock func()) {
+	e.buildsMu.Lock()
+	mu, ok := e.builds[projectPath]
+	if !ok {
+		mu = new(sync.Mutex)
+		e.builds[projectPath] = mu
+	}
+	e.buildsMu.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
+
 // NOTE: We will need to add methods to this Engine struct. For example:
 // func (e *Engine) AddProject(name, path string) error { ... }
 // func (e *Engine) GetProjects() []Project { ... }
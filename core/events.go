@@ -0,0 +1,111 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// BuildEventKind tells subscribers what a BuildEvent is about.
+type BuildEventKind string
+
+const (
+	EventBuildStarted  BuildEventKind = "build-started"
+	EventFileStarted   BuildEventKind = "file-started"
+	EventFileDone      BuildEventKind = "file-done"
+	EventFileSkipped   BuildEventKind = "file-skipped" // A draft, future or expired page left out of the build.
+	EventAssetCopied   BuildEventKind = "asset-copied"
+	EventWarning       BuildEventKind = "warning"
+	EventError         BuildEventKind = "error"
+	EventBuildFinished BuildEventKind = "build-finished"
+)
+
+// BuildEvent is a single progress notification published while a project builds.
+type BuildEvent struct {
+	Project string         `json:"project"`
+	Kind    BuildEventKind `json:"kind"`
+	Path    string         `json:"path,omitempty"`    // The content or asset file the event is about, if any.
+	Message string         `json:"message,omitempty"` // Human readable details, e.g. the build summary.
+	Error   string         `json:"error,omitempty"`   // Set on errors and on a failed build-finished event.
+	Time    time.Time      `json:"time"`
+}
+
+// eventHub fans build events out to every subscriber of an Engine.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan BuildEvent]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[chan BuildEvent]struct{})}
+}
+
+// subscribe registers a new buffered subscriber. The returned function
+// unregisters it and closes the channel.
+func (h *eventHub) subscribe(buffer int) (<-chan BuildEvent, func()) {
+	ch := make(chan BuildEvent, buffer)
+
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subscribers, ch)
+			h.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// publish delivers an event to every subscriber without ever blocking the
+// build: a subscriber whose buffer is full simply misses the event.
+func (h *eventHub) publish(event BuildEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// SubscribeBuildEvents returns a channel receiving the progress events of every
+// build run by this engine, and a function to stop the subscription. Slow
+// subscribers drop events rather than slowing builds down.
+func (e *Engine) SubscribeBuildEvents() (<-chan BuildEvent, func()) {
+	return e.events.subscribe(256)
+}
+
+// buildEmitter publishes the events of one build run, both to the engine's
+// subscribers and to the caller's own Progress callback.
+type buildEmitter struct {
+	mu       sync.Mutex // Serialises Progress calls coming from several workers.
+	project  string
+	progress func(BuildEvent)
+	hub      *eventHub
+}
+
+func (em *buildEmitter) emit(kind BuildEventKind, path, message string) {
+	em.publish(BuildEvent{Kind: kind, Path: path, Message: message})
+}
+
+// emitError publishes an event that carries an error.
+func (em *buildEmitter) emitError(kind BuildEventKind, path, message string, err error) {
+	em.publish(BuildEvent{Kind: kind, Path: path, Message: message, Error: err.Error()})
+}
+
+func (em *buildEmitter) publish(event BuildEvent) {
+	event.Project = em.project
+	event.Time = time.Now()
+
+	em.mu.Lock()
+	if em.progress != nil {
+		em.progress(event)
+	}
+	em.mu.Unlock()
+
+	em.hub.publish(event)
+}
@@ -0,0 +1,377 @@
+package core
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"time"
+)
+
+// Every list page with pages of its own (the home page, sections and terms)
+// gets its feed in three formats, written next to its index.html.
+const (
+	rssFeedFile  = "rss.xml"
+	atomFeedFile = "atom.xml"
+	jsonFeedFile = "feed.json"
+
+	defaultFeedLimit   = 20
+	jsonFeedVersionURL = "https://jsonfeed.org/version/1.1"
+)
+
+// FeedOptions controls the feeds generated for the site, its sections and terms.
+type FeedOptions struct {
+	// Disable turns feed generation off.
+	Disable bool `yaml:"disable" json:"disable" toml:"disable"`
+
+	// Limit is the maximum number of entries per feed, newest first.
+	// Zero means the default of 20.
+	Limit int `yaml:"limit" json:"limit" toml:"limit"`
+
+	// FullContent puts each page's whole rendered content into the feed
+	// instead of its summary.
+	FullContent bool `yaml:"fullContent" json:"fullContent" toml:"fullContent"`
+}
+
+// FeedLink describes one feed of a list page, for <link rel="alternate"> tags.
+type FeedLink struct {
+	Format       string // "rss", "atom" or "json".
+	MediaType    string
+	RelPermalink string
+	Permalink    string
+}
+
+// Feeds returns the feeds of a list page. Regular pages and taxonomy
+// overviews have none.
+func (p *Page) Feeds() []FeedLink {
+	return p.feeds
+}
+
+// feedLinks returns the feed links of a list page.
+func feedLinks(baseURL string, list *Page) []FeedLink {
+	formats := []struct{ format, mediaType, file string }{
+		{"rss", "application/rss+xml", rssFeedFile},
+		{"atom", "application/atom+xml", atomFeedFile},
+		{"json", "application/feed+json", jsonFeedFile},
+	}
+	links := make([]FeedLink, 0, len(formats))
+	for _, f := range formats {
+		relURL := path.Join(list.RelPermalink, f.file)
+		links = append(links, FeedLink{
+			Format:       f.format,
+			MediaType:    f.mediaType,
+			RelPermalink: relURL,
+			Permalink:    absURL(baseURL, relURL),
+		})
+	}
+	return links
+}
+
+// feed is the format independent content of a feed.
+type feed struct {
+	title   string
+	link    string // Absolute URL of the list page.
+	updated time.Time
+	entries []feedEntry
+}
+
+type feedEntry struct {
+	title     string
+	link      string
+	author    string
+	published time.Time
+	updated   time.Time
+	summary   string // Plain text.
+	content   string // HTML, empty unless full content was asked for.
+	tags      []string
+}
+
+// newFeed collects the newest pages of a list page.
+func newFeed(siteTitle string, list *Page, opts FeedOptions) *feed {
+	limit := opts.Limit
+	if limit < 1 {
+		limit = defaultFeedLimit
+	}
+
+	pages := append([]*Page{}, list.regularPages...)
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Date.After(pages[j].Date) })
+	if len(pages) > limit {
+		pages = pages[:limit]
+	}
+
+	title := siteTitle
+	if list.Kind != KindHome && list.Title != "" {
+		title = list.Title + " | " + siteTitle
+	}
+	f := &feed{title: title, link: list.Permalink}
+	for _, page := range pages {
+		entry := feedEntry{
+			title:     page.Title,
+			link:      page.Permalink,
+			author:    page.Author,
+			published: page.Date,
+			updated:   page.Lastmod,
+			summary:   feedSummary(page),
+		}
+		if opts.FullContent {
+			// A page whose content is broken fails its own rendering.
+			content, _ := page.Content()
+			entry.content = string(content)
+		}
+		entry.tags = frontMatterStrings(page.FrontMatter["tags"])
+		if entry.updated.After(f.updated) {
+			f.updated = entry.updated
+		}
+		f.entries = append(f.entries, entry)
+	}
+	if f.updated.IsZero() {
+		// Feeds must carry a date; a list of undated pages gets a fixed one so
+		// the output, and with it the build cache, stays stable.
+		f.updated = time.Unix(0, 0).UTC()
+	}
+	return f
+}
+
+// feedSummary returns the summary of a page as plain text.
+func feedSummary(page *Page) string {
+	// A page whose content is broken fails its own rendering.
+	summary, _ := page.Summary()
+	return plainText(string(summary))
+}
+
+// writeFeed encodes f in the format of the given feed file name.
+func writeFeed(w io.Writer, file, selfURL string, f *feed) error {
+	switch file {
+	case rssFeedFile:
+		return writeRSS(w, selfURL, f)
+	case atomFeedFile:
+		return writeAtom(w, selfURL, f)
+	case jsonFeedFile:
+		return writeJSONFeed(w, selfURL, f)
+	}
+	return fmt.Errorf("unknown feed format %s", file)
+}
+
+// RSS 2.0, with an Atom self link as the RSS Advisory Board recommends.
+type rssDocument struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	GUID        string    `xml:"guid"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Author      string    `xml:"author,omitempty"`
+	Categories  []string  `xml:"category"`
+	Description cdataText `xml:"description"`
+}
+
+type cdataText struct {
+	Text string `xml:",cdata"`
+}
+
+func writeRSS(w io.Writer, selfURL string, f *feed) error {
+	doc := rssDocument{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         f.title,
+			Link:          f.link,
+			Description:   "Recent content on " + f.title,
+			SelfLink:      atomLink{Href: selfURL, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: f.updated.Format(time.RFC1123Z),
+		},
+	}
+	for _, entry := range f.entries {
+		item := rssItem{
+			Title:       entry.title,
+			Link:        entry.link,
+			GUID:        entry.link,
+			Author:      entry.author,
+			Categories:  entry.tags,
+			Description: cdataText{Text: entry.summary},
+		}
+		if entry.content != "" {
+			item.Description.Text = entry.content
+		}
+		if !entry.published.IsZero() {
+			item.PubDate = entry.published.Format(time.RFC1123Z)
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return encodeXML(w, doc)
+}
+
+// Atom (RFC 4287).
+type atomDocument struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Author     *atomPerson    `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+	Content    *atomText      `xml:"content"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Text string `xml:",chardata"`
+}
+
+func writeAtom(w io.Writer, selfURL string, f *feed) error {
+	doc := atomDocument{
+		Title:   f.title,
+		ID:      f.link,
+		Updated: f.updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: selfURL, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.link, Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, entry := range f.entries {
+		updated := entry.updated
+		if updated.IsZero() {
+			updated = f.updated
+		}
+		e := atomEntry{
+			Title:   entry.title,
+			ID:      entry.link,
+			Link:    atomLink{Href: entry.link, Rel: "alternate"},
+			Updated: updated.Format(time.RFC3339),
+			Summary: &atomText{Text: entry.summary},
+		}
+		if !entry.published.IsZero() {
+			e.Published = entry.published.Format(time.RFC3339)
+		}
+		if entry.author != "" {
+			e.Author = &atomPerson{Name: entry.author}
+		}
+		for _, tag := range entry.tags {
+			e.Categories = append(e.Categories, atomCategory{Term: tag})
+		}
+		if entry.content != "" {
+			e.Content = &atomText{Type: "html", Text: entry.content}
+		}
+		doc.Entries = append(doc.Entries, e)
+	}
+	return encodeXML(w, doc)
+}
+
+func encodeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// JSON Feed 1.1 (https://www.jsonfeed.org/version/1.1/).
+type jsonFeedDocument struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url"`
+	Title         string           `json:"title"`
+	Summary       string           `json:"summary,omitempty"`
+	ContentHTML   string           `json:"content_html,omitempty"`
+	ContentText   string           `json:"content_text,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	DateModified  string           `json:"date_modified,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+func writeJSONFeed(w io.Writer, selfURL string, f *feed) error {
+	doc := jsonFeedDocument{
+		Version:     jsonFeedVersionURL,
+		Title:       f.title,
+		HomePageURL: f.link,
+		FeedURL:     selfURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, entry := range f.entries {
+		item := jsonFeedItem{
+			ID:      entry.link,
+			URL:     entry.link,
+			Title:   entry.title,
+			Summary: entry.summary,
+			Tags:    entry.tags,
+		}
+		// An item needs content; the summary stands in for it when only
+		// summaries were asked for.
+		if entry.content != "" {
+			item.ContentHTML = entry.content
+		} else {
+			item.ContentText = entry.summary
+		}
+		if !entry.published.IsZero() {
+			item.DatePublished = entry.published.Format(time.RFC3339)
+		}
+		if !entry.updated.IsZero() {
+			item.DateModified = entry.updated.Format(time.RFC3339)
+		}
+		if entry.author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: entry.author}}
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
@@ -0,0 +1,618 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// templateFuncs returns the functions every theme template, partial and
+// shortcode can use besides Go's built-in ones. The arguments follow Hugo's
+// order, so the value a function works on comes last and can be piped in:
+// {{ .Title | truncate 40 }}. The README lists them with examples.
+func templateFuncs(opts BuildOptions) template.FuncMap {
+	return template.FuncMap{
+		// Dates.
+		"now":        time.Now,
+		"dateFormat": dateFormat,
+
+		// Collections, usually of pages.
+		"where":   where,
+		"sort":    sortCollection,
+		"first":   first,
+		"last":    last,
+		"after":   after,
+		"group":   group,
+		"in":      in,
+		"delimit": delimit,
+		"dict":    dict,
+		"default": defaultValue,
+
+		// URLs.
+		"absURL": func(s string) string { return siteAbsURL(opts.BaseURL, s) },
+		"relURL": func(s string) string { return siteRelURL(opts.BaseURL, s) },
+
+		// Content.
+		"markdownify": func(s interface{}) (template.HTML, error) { return markdownify(toString(s), opts.markdownOptions()) },
+		"plainify":    func(s interface{}) string { return plainText(toString(s)) },
+		"truncate":    truncate,
+		"jsonify":     jsonify,
+		"safeHTML":    func(s interface{}) template.HTML { return template.HTML(toString(s)) },
+		"safeURL":     func(s interface{}) template.URL { return template.URL(toString(s)) },
+		"safeCSS":     func(s interface{}) template.CSS { return template.CSS(toString(s)) },
+		"safeJS":      func(s interface{}) template.JS { return template.JS(toString(s)) },
+
+		// Math.
+		"add": func(a, b interface{}) (interface{}, error) { return arithmetic("add", a, b) },
+		"sub": func(a, b interface{}) (interface{}, error) { return arithmetic("sub", a, b) },
+		"mul": func(a, b interface{}) (interface{}, error) { return arithmetic("mul", a, b) },
+		"div": func(a, b interface{}) (interface{}, error) { return arithmetic("div", a, b) },
+		"mod": func(a, b interface{}) (interface{}, error) { return arithmetic("mod", a, b) },
+
+		// Strings.
+		"lower":     func(s interface{}) string { return strings.ToLower(toString(s)) },
+		"upper":     func(s interface{}) string { return strings.ToUpper(toString(s)) },
+		"title":     func(s interface{}) string { return titleCase(toString(s)) },
+		"trim":      func(s interface{}, cutset string) string { return strings.Trim(toString(s), cutset) },
+		"replace":   func(s interface{}, old, new string) string { return strings.ReplaceAll(toString(s), old, new) },
+		"split":     func(s interface{}, sep string) []string { return strings.Split(toString(s), sep) },
+		"contains":  func(s interface{}, substr string) bool { return strings.Contains(toString(s), substr) },
+		"hasPrefix": func(s interface{}, prefix string) bool { return strings.HasPrefix(toString(s), prefix) },
+		"hasSuffix": func(s interface{}, suffix string) bool { return strings.HasSuffix(toString(s), suffix) },
+		"urlize":    func(s interface{}) string { return slugify(toString(s)) },
+	}
+}
+
+// dateFormat formats a time, or a date string as front matter has it, with a
+// Go layout: {{ .Date | dateFormat "January 2, 2006" }}. Zero times format
+// as an empty string.
+func dateFormat(layout string, value interface{}) (string, error) {
+	t, ok := value.(time.Time)
+	if !ok {
+		t = frontMatterTime(value)
+		if t.IsZero() && value != nil && toString(value) != "" {
+			return "", fmt.Errorf("%q is not a date", toString(value))
+		}
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(layout), nil
+}
+
+// where keeps the elements of a collection whose key matches a value:
+//
+//	{{ where .Site.Pages "Section" "posts" }}
+//	{{ where .Pages "WordCount" ">" 500 }}
+//	{{ where .Pages "FrontMatter.tags" "intersect" (split "go,web" ",") }}
+//
+// The key is a field, a method without arguments or a map key, with dots for
+// nested ones. Operators are =, !=, <, <=, >, >=, in, "not in" and intersect.
+func where(collection interface{}, key string, args ...interface{}) (interface{}, error) {
+	op, match := "=", interface{}(nil)
+	switch len(args) {
+	case 1:
+		match = args[0]
+	case 2:
+		operator, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("operator must be a string, got %T", args[0])
+		}
+		op, match = operator, args[1]
+	default:
+		return nil, errors.New("expected a value, or an operator and a value")
+	}
+
+	items, err := sliceValue(collection)
+	if err != nil {
+		return nil, err
+	}
+	result := reflect.MakeSlice(items.Type(), 0, items.Len())
+	for i := 0; i < items.Len(); i++ {
+		value, err := lookup(items.Index(i), key)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := matches(value, op, match)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result = reflect.Append(result, items.Index(i))
+		}
+	}
+	return result.Interface(), nil
+}
+
+// matches applies a where operator.
+func matches(value interface{}, op string, match interface{}) (bool, error) {
+	switch op {
+	case "=", "==", "eq":
+		return valuesEqual(value, match), nil
+	case "!=", "<>", "ne":
+		return !valuesEqual(value, match), nil
+	case "<", "lt", "<=", "le", ">", "gt", ">=", "ge":
+		if value == nil {
+			return false, nil
+		}
+		c, err := compareValues(value, match)
+		if err != nil {
+			return false, err
+		}
+		switch op {
+		case "<", "lt":
+			return c < 0, nil
+		case "<=", "le":
+			return c <= 0, nil
+		case ">", "gt":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		return in(match, value), nil
+	case "not in":
+		return !in(match, value), nil
+	case "intersect":
+		values, err := sliceValue(value)
+		if err != nil {
+			return false, nil
+		}
+		for i := 0; i < values.Len(); i++ {
+			if in(match, values.Index(i).Interface()) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// sortCollection returns a sorted copy of a collection, by the elements
+// themselves or by a key as where has it, ascending unless the order is
+// "desc": {{ sort .Pages "Title" }}, {{ sort .Pages "Date" "desc" }}.
+func sortCollection(collection interface{}, args ...string) (interface{}, error) {
+	key, order := "", "asc"
+	if len(args) > 0 {
+		key = args[0]
+	}
+	if len(args) > 1 {
+		order = strings.ToLower(args[1])
+	}
+	if order != "asc" && order != "desc" {
+		return nil, fmt.Errorf("order must be asc or desc, got %q", order)
+	}
+
+	items, err := sliceValue(collection)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, items.Len())
+	indices := make([]int, items.Len())
+	for i := range keys {
+		indices[i] = i
+		if key == "" {
+			keys[i] = items.Index(i).Interface()
+		} else if keys[i], err = lookup(items.Index(i), key); err != nil {
+			return nil, err
+		}
+	}
+	var sortErr error
+	sort.SliceStable(indices, func(a, b int) bool {
+		c, err := compareValues(keys[indices[a]], keys[indices[b]])
+		if err != nil && sortErr == nil {
+			sortErr = err
+		}
+		if order == "desc" {
+			return c > 0
+		}
+		return c < 0
+	})
+	if sortErr != nil {
+		return nil, sortErr
+	}
+
+	result := reflect.MakeSlice(items.Type(), 0, items.Len())
+	for _, i := range indices {
+		result = reflect.Append(result, items.Index(i))
+	}
+	return result.Interface(), nil
+}
+
+// first returns the first n elements of a collection: {{ first 5 .Pages }}.
+func first(n int, collection interface{}) (interface{}, error) {
+	items, err := sliceValue(collection)
+	if err != nil {
+		return nil, err
+	}
+	return items.Slice(0, min(max(n, 0), items.Len())).Interface(), nil
+}
+
+// last returns the last n elements of a collection.
+func last(n int, collection interface{}) (interface{}, error) {
+	items, err := sliceValue(collection)
+	if err != nil {
+		return nil, err
+	}
+	return items.Slice(items.Len()-min(max(n, 0), items.Len()), items.Len()).Interface(), nil
+}
+
+// after returns a collection without its first n elements.
+func after(n int, collection interface{}) (interface{}, error) {
+	items, err := sliceValue(collection)
+	if err != nil {
+		return nil, err
+	}
+	return items.Slice(min(max(n, 0), items.Len()), items.Len()).Interface(), nil
+}
+
+// PageGroup is a group of pages returned by the group function.
+type PageGroup struct {
+	Key   string
+	Pages []*Page
+}
+
+// group splits pages by a key as where has it, keeping the order in which the
+// keys first appear. A time key is formatted with the optional layout:
+// {{ range group .Pages "Date" "2006" }}<h2>{{ .Key }}</h2>...{{ end }}.
+func group(pages []*Page, key string, layout ...string) ([]PageGroup, error) {
+	var groups []PageGroup
+	index := make(map[string]int)
+	for _, page := range pages {
+		value, err := lookup(reflect.ValueOf(page), key)
+		if err != nil {
+			return nil, err
+		}
+		name := toString(value)
+		if t, ok := value.(time.Time); ok && len(layout) > 0 {
+			name = t.Format(layout[0])
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, PageGroup{Key: name})
+		}
+		groups[i].Pages = append(groups[i].Pages, page)
+	}
+	return groups, nil
+}
+
+// in reports whether a collection holds an item, or a string a substring.
+func in(collection, item interface{}) bool {
+	if s, ok := collection.(string); ok {
+		return strings.Contains(s, toString(item))
+	}
+	items, err := sliceValue(collection)
+	if err != nil {
+		return false
+	}
+	for i := 0; i < items.Len(); i++ {
+		if valuesEqual(items.Index(i).Interface(), item) {
+			return true
+		}
+	}
+	return false
+}
+
+// delimit joins the elements of a collection: {{ delimit .FrontMatter.tags ", " }}.
+func delimit(collection interface{}, sep string) (string, error) {
+	items, err := sliceValue(collection)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, items.Len())
+	for i := range parts {
+		parts[i] = toString(items.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// dict builds a map from key value pairs, e.g. to pass more than one value
+// to a partial: {{ template "card" (dict "page" . "wide" true) }}.
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("expected key value pairs")
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("key must be a string, got %T", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// defaultValue returns value, or def if value is empty:
+// {{ .FrontMatter.image | default "/img/card.png" }}.
+func defaultValue(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	case reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64, reflect.Pointer, reflect.Interface:
+		if v.IsZero() {
+			return def
+		}
+	}
+	return value
+}
+
+// siteAbsURL makes a URL absolute with the site's base URL. URLs that are
+// absolute already are returned as they are.
+func siteAbsURL(baseURL, s string) string {
+	if u, err := url.Parse(s); err == nil && u.IsAbs() {
+		return s
+	}
+	return absURL(baseURL, "/"+strings.TrimPrefix(s, "/"))
+}
+
+// siteRelURL makes a URL relative to the site root, including the path of
+// the base URL for sites published in a subdirectory.
+func siteRelURL(baseURL, s string) string {
+	if u, err := url.Parse(s); err == nil && u.IsAbs() {
+		return s
+	}
+	if strings.HasPrefix(s, "/") || baseURL == "" {
+		return "/" + strings.TrimPrefix(s, "/")
+	}
+	base := "/"
+	if u, err := url.Parse(baseURL); err == nil && u.Path != "" {
+		base = u.Path
+	}
+	relURL := path.Join(base, s)
+	if strings.HasSuffix(s, "/") {
+		relURL += "/"
+	}
+	return relURL
+}
+
+// markdownify renders a string of Markdown. A single paragraph is returned
+// without its <p>, so the result fits into headings and links.
+func markdownify(s string, opts markdownOptions) (template.HTML, error) {
+	html, _, err := renderMarkdown([]byte(s), opts, nil)
+	if err != nil {
+		return "", err
+	}
+	out := strings.TrimSpace(string(html))
+	if strings.HasPrefix(out, "<p>") && strings.HasSuffix(out, "</p>") && strings.Count(out, "<p>") == 1 {
+		out = strings.TrimSuffix(strings.TrimPrefix(out, "<p>"), "</p>")
+	}
+	return template.HTML(out), nil
+}
+
+// truncate shortens text to at most length characters, cutting at a word
+// boundary where possible and appending an ellipsis ("…" unless given):
+// {{ .Title | truncate 40 }}, {{ truncate 40 "..." .Title }}.
+func truncate(length int, args ...interface{}) (string, error) {
+	ellipsis := "…"
+	var text interface{}
+	switch len(args) {
+	case 1:
+		text = args[0]
+	case 2:
+		ellipsis, text = toString(args[0]), args[1]
+	default:
+		return "", errors.New("expected a length, an optional ellipsis and a text")
+	}
+	s := toString(text)
+	if utf8.RuneCountInString(s) <= length {
+		return s, nil
+	}
+	runes := []rune(s)[:max(length, 0)]
+	cut := string(runes)
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, unicode.IsSpace) + ellipsis, nil
+}
+
+// jsonify encodes a value as JSON, for instance for JSON-LD in a <script>.
+func jsonify(value interface{}) (template.JS, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(data), nil
+}
+
+// arithmetic applies a math function to two numbers. Integers stay integers,
+// anything with a float becomes a float.
+func arithmetic(op string, a, b interface{}) (interface{}, error) {
+	x, xInt, err := toNumber(a)
+	if err != nil {
+		return nil, err
+	}
+	y, yInt, err := toNumber(b)
+	if err != nil {
+		return nil, err
+	}
+	if (op == "div" || op == "mod") && y == 0 {
+		return nil, errors.New("division by zero")
+	}
+	if xInt && yInt {
+		i, j := int(x), int(y)
+		switch op {
+		case "add":
+			return i + j, nil
+		case "sub":
+			return i - j, nil
+		case "mul":
+			return i * j, nil
+		case "div":
+			return i / j, nil
+		}
+		return i % j, nil
+	}
+	switch op {
+	case "add":
+		return x + y, nil
+	case "sub":
+		return x - y, nil
+	case "mul":
+		return x * y, nil
+	case "div":
+		return x / y, nil
+	}
+	return math.Mod(x, y), nil
+}
+
+// toNumber converts a template value to a float, reporting whether it was an integer.
+func toNumber(value interface{}) (float64, bool, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true, nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, nil
+	}
+	return 0, false, fmt.Errorf("%v (%T) is not a number", value, value)
+}
+
+// sliceValue checks that a template value is a slice or an array.
+func sliceValue(collection interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(collection)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return reflect.Value{}, fmt.Errorf("expected a collection, got %T", collection)
+	}
+	return v, nil
+}
+
+// lookup resolves a dotted key like "Date" or "FrontMatter.tags" on a value:
+// each part is a method without arguments, a field or a map key. Keys that
+// do not exist give nil.
+func lookup(v reflect.Value, key string) (interface{}, error) {
+	for _, name := range strings.Split(key, ".") {
+		if !v.IsValid() {
+			return nil, nil
+		}
+		if method := v.MethodByName(name); method.IsValid() && method.Type().NumIn() == 0 {
+			results := method.Call(nil)
+			if len(results) == 2 && !results[1].IsNil() {
+				return nil, results[1].Interface().(error)
+			}
+			v = results[0]
+			continue
+		}
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		switch v.Kind() {
+		case reflect.Struct:
+			field, ok := v.Type().FieldByName(name)
+			if !ok || !field.IsExported() {
+				return nil, fmt.Errorf("%s has no field or method %s", v.Type(), name)
+			}
+			v = v.FieldByIndex(field.Index)
+		case reflect.Map:
+			v = v.MapIndex(reflect.ValueOf(name))
+		default:
+			return nil, fmt.Errorf("cannot look up %s in %s", name, v.Type())
+		}
+	}
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+// valuesEqual compares two template values, numbers by their value and
+// everything else by its string form.
+func valuesEqual(a, b interface{}) bool {
+	if c, err := compareValues(a, b); err == nil {
+		return c == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two numbers, strings, times or booleans.
+func compareValues(a, b interface{}) (int, error) {
+	if x, _, err := toNumber(a); err == nil {
+		if y, _, err := toNumber(b); err == nil {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		if !ok {
+			y = frontMatterTime(b)
+		}
+		return x.Compare(y), nil
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !x:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case string, template.HTML:
+		return strings.Compare(toString(a), toString(b)), nil
+	case nil:
+		if b == nil {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	if b == nil {
+		return 1, nil
+	}
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+// toString converts a template value to a string.
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case template.HTML:
+		return string(v)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(value)
+}
+
+// titleCase upper-cases the first letter of every word.
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		defer func() { prev = r }()
+		if unicode.IsSpace(prev) || prev == '-' {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,203 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Fenced code blocks are highlighted at build time. The info string after
+// the fence names the language and may add options in braces:
+//
+//	```go {3,5-7 linenos=true linenostart=10}
+//
+// highlights lines 3 and 5 to 7 and numbers the lines, starting at 10.
+// Highlighted blocks use CSS classes; the colours come from the stylesheet
+// written to css/syntax.css for the configured style, unless the theme
+// ships a css/syntax.css of its own.
+const (
+	highlightStylesheet   = "css/syntax.css"
+	defaultHighlightStyle = "github"
+)
+
+// HighlightOptions controls the highlighting of fenced code blocks.
+type HighlightOptions struct {
+	Disable     bool   `yaml:"disable" json:"disable" toml:"disable"`             // Leave code blocks as plain <pre><code>.
+	Style       string `yaml:"style" json:"style" toml:"style"`                   // A chroma style such as "github" or "monokai".
+	LineNumbers bool   `yaml:"lineNumbers" json:"lineNumbers" toml:"lineNumbers"` // Number the lines of every block; linenos=false turns it off for one.
+}
+
+// codeBlockOptions are the options of a single code block.
+type codeBlockOptions struct {
+	language    string
+	lineNumbers bool
+	lineStart   int
+	highlight   [][2]int
+}
+
+// parseFenceInfo reads the language and options of a code block's info
+// string, starting from the site wide defaults.
+func parseFenceInfo(info string, opts HighlightOptions) (codeBlockOptions, error) {
+	block := codeBlockOptions{lineNumbers: opts.LineNumbers, lineStart: 1}
+	info = strings.TrimSpace(info)
+	attrs := ""
+	if i := strings.IndexByte(info, '{'); i >= 0 {
+		if !strings.HasSuffix(info, "}") {
+			return block, fmt.Errorf("code block options %q are not closed with }", info[i:])
+		}
+		attrs = info[i+1 : len(info)-1]
+		info = strings.TrimSpace(info[:i])
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		block.language = fields[0]
+	}
+
+	for _, attr := range strings.Fields(attrs) {
+		key, value, isOption := strings.Cut(attr, "=")
+		if !isOption {
+			key, value = "hl_lines", attr
+		}
+		value = strings.Trim(value, `"'`)
+		switch key {
+		case "hl_lines":
+			ranges, err := parseLineRanges(value)
+			if err != nil {
+				return block, err
+			}
+			block.highlight = append(block.highlight, ranges...)
+		case "linenos":
+			on, err := strconv.ParseBool(value)
+			if err != nil {
+				return block, fmt.Errorf("linenos must be true or false, got %q", value)
+			}
+			block.lineNumbers = on
+		case "linenostart":
+			start, err := strconv.Atoi(value)
+			if err != nil {
+				return block, fmt.Errorf("linenostart must be a number, got %q", value)
+			}
+			block.lineStart = start
+		default:
+			return block, fmt.Errorf("unknown code block option %q, expected line ranges, hl_lines, linenos or linenostart", key)
+		}
+	}
+	return block, nil
+}
+
+// parseLineRanges parses line ranges such as "3,5-7".
+func parseLineRanges(s string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, part := range strings.Split(s, ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 1 || to < from {
+			return nil, fmt.Errorf("invalid line range %q, expected e.g. 3 or 5-7", part)
+		}
+		ranges = append(ranges, [2]int{from, to})
+	}
+	return ranges, nil
+}
+
+// fenceError is a code block whose options cannot be parsed.
+type fenceError struct {
+	info string // The info string, to find the block in the source.
+	err  error
+}
+
+func (e *fenceError) Error() string { return e.err.Error() }
+
+// codeBlockHook returns a gomarkdown render hook that highlights code blocks.
+// The first broken info string is reported through errp.
+func codeBlockHook(opts HighlightOptions, errp *error) func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	return func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+		code, ok := node.(*ast.CodeBlock)
+		if !ok || opts.Disable {
+			return ast.GoToNext, false
+		}
+		block, err := parseFenceInfo(string(code.Info), opts)
+		if err != nil {
+			if *errp == nil {
+				*errp = &fenceError{info: string(code.Info), err: err}
+			}
+			return ast.GoToNext, false
+		}
+		if err := highlightCode(w, string(code.Literal), block); err != nil {
+			if *errp == nil {
+				*errp = err
+			}
+			return ast.GoToNext, false
+		}
+		io.WriteString(w, "\n")
+		return ast.GoToNext, true
+	}
+}
+
+// highlightCode writes a highlighted code block.
+func highlightCode(w io.Writer, source string, block codeBlockOptions) error {
+	lexer := lexers.Get(block.language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+	tokens, err := lexer.Tokenise(nil, source)
+	if err != nil {
+		return fmt.Errorf("could not highlight %s code: %w", block.language, err)
+	}
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(block.lineNumbers),
+		chromahtml.LineNumbersInTable(true),
+		chromahtml.BaseLineNumber(block.lineStart),
+		chromahtml.HighlightLines(block.highlight),
+	)
+	// The style only matters for inline styles; the colours are in the stylesheet.
+	return formatter.Format(w, styles.Fallback, tokens)
+}
+
+// highlightCSS returns the stylesheet of a chroma style.
+func highlightCSS(style string) (string, error) {
+	var b strings.Builder
+	formatter := chromahtml.New(chromahtml.WithClasses(true), chromahtml.WithLineNumbers(true), chromahtml.LineNumbersInTable(true))
+	if err := formatter.WriteCSS(&b, styles.Get(style)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validHighlightStyle reports whether chroma knows a style.
+func validHighlightStyle(style string) bool {
+	_, ok := styles.Registry[style]
+	return ok
+}
+
+// planHighlightStylesheet writes the stylesheet of the highlighting style,
+// making way for one the project or its theme ships itself.
+func planHighlightStylesheet(state *buildState) error {
+	if state.opts.Highlight.Disable {
+		return nil
+	}
+	// Outputs are claimed under slash separated paths on every platform.
+	if _, taken := state.owners[highlightStylesheet]; taken {
+		return nil
+	}
+	css, err := highlightCSS(state.opts.Highlight.Style)
+	if err != nil {
+		return fmt.Errorf("could not write the highlighting stylesheet: %w", err)
+	}
+	return planTextFile(state, filepath.FromSlash(highlightStylesheet), "highlight style "+state.opts.Highlight.Style, css)
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// MenuEntryConfig is a menu entry configured in site.yaml. Menus are named
+// lists of links for a site's navigation, with entries from site.yaml:
+//
+//	menus:
+//	  main:
+//	    - name: Docs
+//	      url: /docs/
+//	      weight: 10
+//	    - name: GitHub
+//	      url: https://github.com/example/site
+//	      weight: 100
+//
+// and from the `menu` front matter of pages, which adds the page itself:
+//
+//	menu: main                 # or a list: [main, footer]
+//	menu:
+//	  main:
+//	    name: Install          # defaults to the page's title
+//	    weight: 20
+//	    parent: Docs           # the identifier (or name) of another entry
+//
+// Entries are sorted by weight, then by name; entries without a weight come
+// last. Templates find the menus in .Site.Menus, e.g. .Site.Menus.main.
+type MenuEntryConfig struct {
+	Identifier string `yaml:"identifier" json:"identifier" toml:"identifier"` // Defaults to the name; used as the parent of other entries.
+	Name       string `yaml:"name" json:"name" toml:"name"`
+	URL        string `yaml:"url" json:"url" toml:"url"` // A site path like "/docs/" or an external URL.
+	Weight     int    `yaml:"weight" json:"weight" toml:"weight"`
+	Parent     string `yaml:"parent" json:"parent" toml:"parent"` // The identifier of the entry this one is nested under.
+}
+
+// Menu is an ordered list of menu entries.
+type Menu []*MenuEntry
+
+// MenuEntry is one link of a menu as templates see it.
+type MenuEntry struct {
+	Identifier string
+	Name       string
+	URL        string
+	Weight     int
+	Parent     string
+	Children   Menu  `json:",omitempty"`
+	Page       *Page `json:"-"` // The page the entry links to, if it links to one of the site's pages.
+}
+
+// IsExternal reports whether the entry links to another site.
+func (e *MenuEntry) IsExternal() bool {
+	u, err := url.Parse(e.URL)
+	return err == nil && (u.IsAbs() || u.Host != "")
+}
+
+// HasChildren reports whether entries are nested under this one.
+func (e *MenuEntry) HasChildren() bool {
+	return len(e.Children) > 0
+}
+
+// IsCurrent reports whether the entry links to the page.
+func (e *MenuEntry) IsCurrent(page *Page) bool {
+	return page != nil && !e.IsExternal() && sameMenuURL(e.URL, page.RelPermalink)
+}
+
+// sameMenuURL reports whether two site paths are the same page, with or
+// without a trailing slash: "url: /docs" in site.yaml is the page /docs/.
+func sameMenuURL(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// IsActive reports whether the entry leads to the page: it links to the page
+// itself, to one of the sections the page is in (the home page aside), or one
+// of its children is active. Use it to highlight the current navigation path:
+//
+//	{{ range .Site.Menus.main }}<a href="{{ .URL }}" {{ if .IsActive $ }}class="active"{{ end }}>{{ .Name }}</a>{{ end }}
+func (e *MenuEntry) IsActive(page *Page) bool {
+	if page == nil {
+		return false
+	}
+	if e.IsCurrent(page) {
+		return true
+	}
+	for parent := page.Parent; parent != nil && !parent.IsHome(); parent = parent.Parent {
+		if e.IsCurrent(parent) {
+			return true
+		}
+	}
+	for _, child := range e.Children {
+		if child.IsActive(page) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateMenus checks the menus of the site configuration.
+func validateMenus(menus map[string][]MenuEntryConfig) []error {
+	var errs []error
+	for _, name := range sortedKeys(menus) {
+		for i, entry := range menus[name] {
+			if entry.Name == "" {
+				errs = append(errs, fmt.Errorf("menus.%s[%d] has no name", name, i))
+			}
+			if entry.URL == "" {
+				errs = append(errs, fmt.Errorf("menus.%s[%d] has no url", name, i))
+			} else if _, err := url.Parse(entry.URL); err != nil {
+				errs = append(errs, fmt.Errorf("menus.%s[%d]: url %q is not a URL", name, i, entry.URL))
+			}
+		}
+	}
+	return errs
+}
+
+// buildMenus assembles the menus of the site configuration and of the pages'
+// front matter into nested, sorted menus.
+func buildMenus(config map[string][]MenuEntryConfig, content *siteContent) (map[string]Menu, error) {
+	entries := make(map[string][]*MenuEntry)
+	for name, menu := range config {
+		for _, entry := range menu {
+			entries[name] = append(entries[name], &MenuEntry{
+				Identifier: entry.Identifier,
+				Name:       entry.Name,
+				URL:        entry.URL,
+				Weight:     entry.Weight,
+				Parent:     entry.Parent,
+			})
+		}
+	}
+
+	// Entries of pages, including list pages with an _index.md.
+	pages := append([]*Page(nil), content.pages...)
+	for _, section := range content.sortedSections() {
+		if section.File != "" {
+			pages = append(pages, section)
+		}
+	}
+	byURL := make(map[string]*Page, len(pages))
+	var errs []error
+	for _, page := range pages {
+		byURL[strings.TrimSuffix(page.RelPermalink, "/")] = page
+		pageEntries, err := pageMenuEntries(page)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("content/%s: %w", page.File, err))
+			continue
+		}
+		for name, entry := range pageEntries {
+			entries[name] = append(entries[name], entry)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	menus := make(map[string]Menu, len(entries))
+	for _, name := range sortedKeys(entries) {
+		for _, entry := range entries[name] {
+			if entry.Identifier == "" {
+				entry.Identifier = entry.Name
+			}
+			if entry.Page == nil && !entry.IsExternal() {
+				entry.Page = byURL[strings.TrimSuffix(entry.URL, "/")]
+			}
+		}
+		menu, err := nestMenu(entries[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("menu %q: %w", name, err))
+			continue
+		}
+		menus[name] = menu
+	}
+	return menus, errors.Join(errs...)
+}
+
+// pageMenuEntries reads the `menu` front matter of a page, which names one
+// menu, lists several, or maps menu names to the settings of the entry.
+func pageMenuEntries(page *Page) (map[string]*MenuEntry, error) {
+	entry := func() *MenuEntry {
+		return &MenuEntry{Name: page.Title, URL: page.RelPermalink, Weight: page.Weight, Page: page}
+	}
+	entries := make(map[string]*MenuEntry)
+	switch menu := page.FrontMatter["menu"].(type) {
+	case nil:
+	case string:
+		entries[menu] = entry()
+	case []interface{}:
+		for _, name := range menu {
+			name, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("menu must list menu names, got %v", menu)
+			}
+			entries[name] = entry()
+		}
+	case map[string]interface{}:
+		for name, settings := range menu {
+			e := entry()
+			if settings != nil {
+				settings, ok := settings.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("menu.%s must hold name, weight, parent or identifier, got %v", name, menu[name])
+				}
+				for key, value := range settings {
+					var ok bool
+					switch key {
+					case "name":
+						e.Name, ok = value.(string)
+					case "identifier":
+						e.Identifier, ok = value.(string)
+					case "parent":
+						e.Parent, ok = value.(string)
+					case "weight":
+						weight, _, err := toNumber(value)
+						e.Weight, ok = int(weight), err == nil
+					default:
+						return nil, fmt.Errorf("menu.%s has an unknown setting %q", name, key)
+					}
+					if !ok {
+						return nil, fmt.Errorf("menu.%s.%s has an invalid value %v", name, key, value)
+					}
+				}
+			}
+			entries[name] = e
+		}
+	default:
+		return nil, fmt.Errorf("menu must be a menu name, a list of names or a map, got %v", menu)
+	}
+	return entries, nil
+}
+
+// nestMenu puts every entry with a parent under that parent and sorts every
+// level of the menu.
+func nestMenu(entries []*MenuEntry) (Menu, error) {
+	byID := make(map[string]*MenuEntry, len(entries))
+	for _, entry := range entries {
+		if _, ok := byID[entry.Identifier]; ok {
+			return nil, fmt.Errorf("two entries are identified as %q; give one an identifier of its own", entry.Identifier)
+		}
+		byID[entry.Identifier] = entry
+	}
+
+	var menu Menu
+	for _, entry := range entries {
+		if entry.Parent == "" {
+			menu = append(menu, entry)
+			continue
+		}
+		parent, ok := byID[entry.Parent]
+		if !ok {
+			return nil, fmt.Errorf("entry %q has the unknown parent %q", entry.Name, entry.Parent)
+		}
+		parent.Children = append(parent.Children, entry)
+	}
+
+	// Entries whose parents form a cycle never reach the top level.
+	reached := 0
+	var sortLevel func(menu Menu)
+	sortLevel = func(menu Menu) {
+		sortMenu(menu)
+		for _, entry := range menu {
+			reached++
+			sortLevel(entry.Children)
+		}
+	}
+	sortLevel(menu)
+	if reached != len(entries) {
+		return nil, errors.New("the parents of some entries form a cycle")
+	}
+	return menu, nil
+}
+
+// sortMenu orders entries by weight, unweighted ones last, then by name.
+func sortMenu(menu Menu) {
+	sort.SliceStable(menu, func(i, j int) bool {
+		a, b := menu[i], menu[j]
+		if a.Weight != b.Weight {
+			if a.Weight == 0 || b.Weight == 0 {
+				return b.Weight == 0
+			}
+			return a.Weight < b.Weight
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"path"
+)
+
+// defaultPageSize is the number of pages per list page when neither the
+// build options nor the section's _index.md say otherwise.
+const defaultPageSize = 10
+
+// Pager is one page of a paginated list. The first pager is the list page
+// itself; the others are written to page/2/, page/3/ and so on.
+type Pager struct {
+	PageNumber int     // 1-based.
+	Pages      []*Page // The pages shown on this pager.
+	URL        string  // URL path of this pager.
+
+	pagers []*Pager // All pagers of the list, shared between them.
+}
+
+// TotalPages returns the number of pagers of the list.
+func (p *Pager) TotalPages() int { return len(p.pagers) }
+
+// Pagers returns all pagers of the list, for numbered navigation.
+func (p *Pager) Pagers() []*Pager { return p.pagers }
+
+// First returns the first pager.
+func (p *Pager) First() *Pager { return p.pagers[0] }
+
+// Last returns the last pager.
+func (p *Pager) Last() *Pager { return p.pagers[len(p.pagers)-1] }
+
+// HasPrev reports whether there is a pager before this one.
+func (p *Pager) HasPrev() bool { return p.PageNumber > 1 }
+
+// HasNext reports whether there is a pager after this one.
+func (p *Pager) HasNext() bool { return p.PageNumber < len(p.pagers) }
+
+// Prev returns the previous pager, or nil on the first one.
+func (p *Pager) Prev() *Pager {
+	if !p.HasPrev() {
+		return nil
+	}
+	return p.pagers[p.PageNumber-2]
+}
+
+// Next returns the next pager, or nil on the last one.
+func (p *Pager) Next() *Pager {
+	if !p.HasNext() {
+		return nil
+	}
+	return p.pagers[p.PageNumber]
+}
+
+// paginate splits pages into pagers of size pages each. There is always at
+// least one pager, so an empty list still gets its list page.
+func paginate(pages []*Page, size int, listURL string) []*Pager {
+	if size < 1 {
+		size = defaultPageSize
+	}
+
+	var pagers []*Pager
+	for start := 0; start < len(pages) || start == 0; start += size {
+		end := min(start+size, len(pages))
+		number := len(pagers) + 1
+		pagers = append(pagers, &Pager{
+			PageNumber: number,
+			Pages:      pages[start:end],
+			URL:        pagerURL(listURL, number),
+		})
+	}
+	for _, pager := range pagers {
+		pager.pagers = pagers
+	}
+	return pagers
+}
+
+// pagerURL returns the URL path of the n-th pager of a list.
+func pagerURL(listURL string, n int) string {
+	if n == 1 {
+		return listURL
+	}
+	return path.Join(listURL, "page", fmt.Sprint(n)) + "/"
+}
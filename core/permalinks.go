@@ -0,0 +1,161 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Permalink patterns are set per section in site.yaml:
+//
+//	permalinks:
+//	  posts: /:year/:month/:slug/
+//
+// A pattern ending in a slash gives every page a directory of its own
+// (posts/hello/index.html); other patterns get ".html" appended unless
+// prettyURLs is on. A page's `url` front matter replaces the pattern
+// entirely; its `slug` is used for :slug (which otherwise is the slugified
+// title) and replaces the file name in the default URL.
+var permalinkTokens = map[string]func(page *Page) string{
+	"year":    func(p *Page) string { return fmt.Sprintf("%04d", permalinkDate(p).Year()) },
+	"month":   func(p *Page) string { return fmt.Sprintf("%02d", int(permalinkDate(p).Month())) },
+	"day":     func(p *Page) string { return fmt.Sprintf("%02d", permalinkDate(p).Day()) },
+	"section": func(p *Page) string { return p.Section },
+	"slug": func(p *Page) string {
+		if p.Slug != "" {
+			return p.Slug
+		}
+		return titleSlug(p)
+	},
+	"title":    titleSlug,
+	"filename": func(p *Page) string { return baseName(p.File) },
+}
+
+var permalinkTokenPattern = regexp.MustCompile(`:[a-z]+`)
+
+// validatePermalink checks a pattern from site.yaml.
+func validatePermalink(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("must start with a slash")
+	}
+	for _, token := range permalinkTokenPattern.FindAllString(pattern, -1) {
+		if _, ok := permalinkTokens[token[1:]]; !ok {
+			return fmt.Errorf("unknown token %s, expected one of :year, :month, :day, :section, :slug, :title, :filename", token)
+		}
+	}
+	return nil
+}
+
+// applyPermalinks sets the URL of every regular page from its `url` front
+// matter, its section's permalink pattern or, by default, its location in
+// content/.
+func (c *siteContent) applyPermalinks(patterns map[string]string, pretty bool) error {
+	for _, page := range c.pages {
+		relPermalink, err := pagePermalink(page, patterns, pretty)
+		if err != nil {
+			return err
+		}
+		page.RelPermalink = relPermalink
+	}
+	return nil
+}
+
+// pagePermalink works out the URL path of a single regular page.
+func pagePermalink(page *Page, patterns map[string]string, pretty bool) (string, error) {
+	name := page.Slug
+	if name == "" {
+		name = baseName(page.File)
+	}
+	urlPath := "/" + path.Join(path.Dir(page.File), name)
+
+	if override, ok := page.FrontMatter["url"].(string); ok && override != "" {
+		if !strings.HasPrefix(override, "/") {
+			return "", fmt.Errorf("url %q in %s must start with a slash", override, page.File)
+		}
+		urlPath = override
+	} else if pattern, ok := patterns[page.Section]; ok {
+		urlPath = permalinkTokenPattern.ReplaceAllStringFunc(pattern, func(token string) string {
+			return permalinkTokens[token[1:]](page)
+		})
+	}
+	return normalizePermalink(urlPath, pretty), nil
+}
+
+// contentPermalink returns the URL path a content file of a project is
+// published under, as a build with the project's site.yaml would choose it.
+func contentPermalink(projectPath, relPath string) (string, error) {
+	config, err := loadSiteConfig(projectPath)
+	if err != nil {
+		return "", err
+	}
+	page, err := loadPage(filepath.Join(projectPath, "content", relPath), filepath.ToSlash(relPath))
+	if err != nil {
+		return "", err
+	}
+	return pagePermalink(page, config.Permalinks, config.PrettyURLs)
+}
+
+// pagePublishedAt returns the content file among files, other than those in
+// except, whose page is published under urlPath, or "" when there is none.
+// Files that cannot be parsed are no page of the site and are skipped.
+func pagePublishedAt(projectPath, urlPath string, files []string, except ...string) string {
+	config, err := loadSiteConfig(projectPath)
+	if err != nil {
+		return ""
+	}
+	for _, relPath := range files {
+		if !strings.HasSuffix(relPath, ".md") || filepath.Base(relPath) == sectionIndexFile || slices.Contains(except, relPath) {
+			continue
+		}
+		page, err := loadPage(filepath.Join(projectPath, "content", relPath), filepath.ToSlash(relPath))
+		if err != nil {
+			continue
+		}
+		if permalink, err := pagePermalink(page, config.Permalinks, config.PrettyURLs); err == nil && permalink == urlPath {
+			return relPath
+		}
+	}
+	return ""
+}
+
+// normalizePermalink cleans up a URL path and decides between a directory
+// ("/a/b/", written as a/b/index.html) and a file ("/a/b.html").
+func normalizePermalink(urlPath string, pretty bool) string {
+	dir := strings.HasSuffix(urlPath, "/")
+	urlPath = path.Clean("/" + urlPath)
+	switch {
+	case urlPath == "/":
+		return urlPath
+	case dir || (pretty && path.Ext(urlPath) == ""):
+		return urlPath + "/"
+	case path.Ext(urlPath) == "":
+		return urlPath + ".html"
+	}
+	return urlPath
+}
+
+// titleSlug returns the slugified title of a page, or its file name when the
+// title has nothing to slugify.
+func titleSlug(page *Page) string {
+	if slug := slugify(page.Title); slug != "" {
+		return slug
+	}
+	return baseName(page.File)
+}
+
+// baseName returns a file name without directory and extension.
+func baseName(file string) string {
+	return strings.TrimSuffix(path.Base(file), path.Ext(file))
+}
+
+// permalinkDate is the date used for :year, :month and :day.
+func permalinkDate(page *Page) time.Time {
+	if !page.Date.IsZero() {
+		return page.Date
+	}
+	return page.modTime
+}
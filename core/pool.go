@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// renderJob is a single unit of work handed to the worker pool, usually the
+// rendering of one page or the copy of one file.
+type renderJob struct {
+	source string // The file the job works on, for logging and error messages.
+	run    func() error
+}
+
+// defaultWorkers is the pool size used when BuildOptions.Workers is not set.
+func defaultWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
+
+// runJobs executes jobs on at most `workers` goroutines and waits for all of
+// them. Every job runs even if others fail; the returned error joins the
+// failures in job order, so the report is the same no matter how the jobs
+// were scheduled. Failures are deduplicated by their err.Error() text, so
+// those of different pages only merge when their messages are identical;
+// callers should not count on one entry per failed page. Once ctx is
+// cancelled no further jobs are started and the context's error is returned.
+func runJobs(ctx context.Context, jobs []renderJob, workers int) error {
+	if workers < 1 {
+		workers = defaultWorkers()
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	errs := make([]error, len(jobs))
+	next := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() != nil {
+					continue
+				}
+				errs[i] = jobs[i].run()
+			}
+		}()
+	}
+
+dispatch:
+	for i := range jobs {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// An error in a page's content fails every output that renders the
+	// content, like the page, its list pages and feeds, with the same error.
+	var failures []error
+	seen := make(map[string]bool)
+	for _, err := range errs {
+		if err == nil || seen[err.Error()] {
+			continue
+		}
+		seen[err.Error()] = true
+		failures = append(failures, err)
+	}
+	return errors.Join(failures...)
+}
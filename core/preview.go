@@ -0,0 +1,354 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// previewDir is where the preview server builds a project, relative to the
+// project. It is kept apart from public/, which only ever holds what a normal
+// build publishes, because previews usually include drafts.
+const previewDir = ".gossg/preview"
+
+// Paths served by the preview server itself rather than from the build output.
+const (
+	liveReloadEventsPath = "/__gossg/livereload"
+	liveReloadScriptPath = "/__gossg/livereload.js"
+)
+
+// liveReloadScript reconnects on its own when the server restarts, and reloads
+// the page whenever a rebuild succeeded.
+const liveReloadScript = `(() => {
+	const source = new EventSource("` + liveReloadEventsPath + `");
+	source.addEventListener("reload", () => location.reload());
+	source.addEventListener("build-error", (msg) => console.error("GoSSG build failed:\n" + msg.data));
+})();
+`
+
+// PreviewOptions configures a preview server. The zero value is usable.
+type PreviewOptions struct {
+	// Addr is the address to listen on. Defaults to "127.0.0.1:1313";
+	// use port 0 to pick any free port.
+	Addr string
+
+	// PollInterval is how often the sources are checked for changes.
+	// Defaults to half a second.
+	PollInterval time.Duration
+
+	// Build is passed to every build the preview server runs.
+	Build BuildOptions
+
+	// OnBuild, if set, is called after every build with its outcome.
+	OnBuild func(*BuildReport, error)
+}
+
+// PreviewServer builds a project into previewDir and serves it from there,
+// rebuilds the project whenever its sources change and tells open browsers to
+// reload. The reload script is only ever injected by this server, and public/
+// is never touched by it.
+type PreviewServer struct {
+	engine    *Engine
+	project   *Project
+	opts      PreviewOptions
+	outputDir string
+	listener  net.Listener
+	done      chan struct{}
+	err       error
+
+	mu      sync.Mutex
+	clients map[chan previewMessage]struct{}
+}
+
+// previewMessage is a single Server-Sent Event sent to the browsers.
+type previewMessage struct {
+	event string
+	data  string
+}
+
+// StartPreview builds a project once, starts serving it and keeps watching its
+// sources until ctx is cancelled. A failing build does not stop the server:
+// the last good output stays online and browsers are told about the error.
+func (e *Engine) StartPreview(ctx context.Context, projectName string, opts PreviewOptions) (*PreviewServer, error) {
+	project, err := e.FindProjectByName(projectName)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Addr == "" {
+		opts.Addr = "127.0.0.1:1313"
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 500 * time.Millisecond
+	}
+	opts.Build.preview = true
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not start preview server: %w", err)
+	}
+
+	p := &PreviewServer{
+		engine:    e,
+		project:   project,
+		opts:      opts,
+		outputDir: filepath.Join(project.Path, filepath.FromSlash(previewDir)),
+		listener:  listener,
+		done:      make(chan struct{}),
+		clients:   make(map[chan previewMessage]struct{}),
+	}
+
+	// Take the snapshot before the first build, so edits made while it runs are not missed.
+	snapshot := p.snapshot()
+	p.rebuild(ctx)
+
+	server := &http.Server{Handler: p}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	go p.watch(ctx, snapshot)
+	go func() {
+		defer close(p.done)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.err = err
+		}
+	}()
+
+	log.Printf("Preview of '%s' running at %s", project.Name, p.URL())
+	return p, nil
+}
+
+// URL returns the address browsers should open.
+func (p *PreviewServer) URL() string {
+	return "http://" + p.listener.Addr().String() + "/"
+}
+
+// Wait blocks until the server stopped and returns the error that stopped it, if any.
+func (p *PreviewServer) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// ServeHTTP serves the live reload endpoints and the built site.
+func (p *PreviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case liveReloadEventsPath:
+		p.serveEvents(w, r)
+		return
+	case liveReloadScriptPath:
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		fmt.Fprint(w, liveReloadScript)
+		return
+	}
+
+	// Resolve the request to a file of the output, the same way a static host would.
+	name := path.Clean("/" + r.URL.Path)
+	fullPath := filepath.Join(p.outputDir, filepath.FromSlash(name))
+	if info, err := os.Stat(fullPath); err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		fullPath = filepath.Join(fullPath, "index.html")
+	}
+
+	status := http.StatusOK
+	if _, err := os.Stat(fullPath); err != nil {
+		notFound := filepath.Join(p.outputDir, "404.html")
+		if _, err := os.Stat(notFound); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		fullPath, status = notFound, http.StatusNotFound
+	}
+
+	if !strings.HasSuffix(fullPath, ".html") {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(w, r, fullPath)
+		return
+	}
+
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	w.Write(injectLiveReload(data))
+}
+
+// injectLiveReload adds the reload script right before </body>, or at the
+// end of documents that have none.
+func injectLiveReload(html []byte) []byte {
+	tag := []byte(`<script src="` + liveReloadScriptPath + `"></script>`)
+	i := bytes.LastIndex(bytes.ToLower(html), []byte("</body>"))
+	if i < 0 {
+		return append(html, tag...)
+	}
+	var out bytes.Buffer
+	out.Grow(len(html) + len(tag))
+	out.Write(html[:i])
+	out.Write(tag)
+	out.Write(html[i:])
+	return out.Bytes()
+}
+
+// serveEvents keeps a Server-Sent Events stream open and forwards reload
+// and build error notifications to the browser.
+func (p *PreviewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	messages := make(chan previewMessage, 4)
+	p.mu.Lock()
+	p.clients[messages] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.clients, messages)
+		p.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-p.done:
+			return
+		case msg := <-messages:
+			// SSE data lines cannot contain newlines, so multi-line errors are split up.
+			fmt.Fprintf(w, "event: %s\n", msg.event)
+			for _, line := range strings.Split(msg.data, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// broadcast sends a message to every connected browser, skipping those that
+// are not keeping up.
+func (p *PreviewServer) broadcast(msg previewMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for client := range p.clients {
+		select {
+		case client <- msg:
+		default:
+		}
+	}
+}
+
+// rebuild runs a build and notifies browsers about its outcome.
+func (p *PreviewServer) rebuild(ctx context.Context) {
+	report, err := p.engine.BuildProjectContext(ctx, p.project.Name, p.opts.Build)
+	if ctx.Err() != nil {
+		return
+	}
+	if p.opts.OnBuild != nil {
+		p.opts.OnBuild(report, err)
+	}
+	if err != nil {
+		log.Printf("Preview build of '%s' failed: %v", p.project.Name, err)
+		p.broadcast(previewMessage{event: "build-error", data: err.Error()})
+		return
+	}
+	p.broadcast(previewMessage{event: "reload", data: "{}"})
+}
+
+// watchRoots lists the files and directories whose changes trigger a rebuild.
+func (p *PreviewServer) watchRoots() []string {
+	roots := []string{
+		filepath.Join(p.project.Path, "content"),
+		filepath.Join(p.project.Path, "themes"),
+		filepath.Join(p.project.Path, projectLayoutsDir),
+		filepath.Join(p.project.Path, projectStaticDir),
+		filepath.Join(p.project.Path, dataDir),
+	}
+	for _, name := range siteConfigFiles {
+		roots = append(roots, filepath.Join(p.project.Path, name))
+	}
+	return roots
+}
+
+// fileStamp is what the watcher compares to notice a changed file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot records the modification time and size of every watched file.
+// Polling keeps the watcher dependency free and behaves the same on every
+// platform and file system, including network shares.
+func (p *PreviewServer) snapshot() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, root := range p.watchRoots() {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // Missing roots and vanished files simply are not part of the snapshot.
+			}
+			if !info.IsDir() {
+				stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			}
+			return nil
+		})
+	}
+	return stamps
+}
+
+// watch polls the sources and rebuilds whenever something changed.
+func (p *PreviewServer) watch(ctx context.Context, last map[string]fileStamp) {
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current := p.snapshot()
+			if sameSnapshot(last, current) {
+				continue
+			}
+			last = current
+			log.Printf("Change detected in '%s', rebuilding...", p.project.Name)
+			p.rebuild(ctx)
+		}
+	}
+}
+
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		other, ok := b[path]
+		if !ok || !other.modTime.Equal(stamp.modTime) || other.size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
@@ -87,10 +87,7 @@ func (e *Engine) AddProject(name, customPath string) error {
 
 	log.Printf("Creating new project '%s' at: %s\n", name, projectPath)
 
-	dirs := []string{
-		"content", "public", "themes/default/templates/partials",
-		"themes/default/static/css", "themes/default/static/js", "addons",
-	}
+	dirs := []string{"content", "public", "addons"}
 
 	for _, dir := range dirs {
 		fullPath := filepath.Join(projectPath, dir)
@@ -99,10 +96,30 @@ func (e *Engine) AddProject(name, customPath string) error {
 		}
 	}
 
+	if err := writeDefaultSiteConfig(projectPath, name); err != nil {
+		return err
+	}
+	// A copy of the built-in theme makes the project build right away and
+	// gives its author something to adapt.
+	if err := writeBuiltinTheme(filepath.Join(projectPath, "themes", defaultThemeName)); err != nil {
+		return fmt.Errorf("failed to write default theme: %w", err)
+	}
+
 	e.config.Projects = append(e.config.Projects, Project{Name: name, Path: projectPath})
 	return e.saveConfig()
 }
 
+// RemoveProject forgets a project. Its files on disk are left untouched.
+func (e *Engine) RemoveProject(name string) error {
+	for i, p := range e.config.Projects {
+		if p.Name == name {
+			e.config.Projects = append(e.config.Projects[:i], e.config.Projects[i+1:]...)
+			return e.saveConfig()
+		}
+	}
+	return fmt.Errorf("project '%s' not found", name)
+}
+
 // GetProjects returns the current list of projects.
 func (e *Engine) GetProjects() []Project {
 	return e.config.Projects
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Publication states of a content file, as shown in the dashboard.
+const (
+	StatusPublished = "published"
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled" // publishDate is still in the future.
+	StatusExpired   = "expired"   // expiryDate has passed.
+	StatusFile      = "file"      // Not a Markdown page but a file copied as it is.
+	StatusInvalid   = "invalid"   // A page that cannot be parsed.
+)
+
+// status returns the publication state of a page at the given time.
+func (p *Page) status(now time.Time) string {
+	switch {
+	case p.Draft:
+		return StatusDraft
+	case p.PublishDate.After(now):
+		return StatusScheduled
+	case !p.ExpiryDate.IsZero() && !p.ExpiryDate.After(now):
+		return StatusExpired
+	}
+	return StatusPublished
+}
+
+// publishes reports whether a build with these options renders a page in the
+// given state. Only published pages are rendered unless the options ask for more.
+func (opts BuildOptions) publishes(status string) bool {
+	switch status {
+	case StatusDraft:
+		return opts.Drafts
+	case StatusScheduled:
+		return opts.Future
+	case StatusExpired:
+		return opts.Expired
+	}
+	return true
+}
+
+// ContentFile is a file in a project's content directory with its
+// publication state.
+type ContentFile struct {
+	Path   string // Relative to content/, as returned by ListContentFiles.
+	Status string // One of the Status constants.
+	Title  string
+	Error  string // Why the file could not be parsed or rendered, for StatusInvalid.
+
+	// For pages, unless the site configuration or theme is broken.
+	Summary     string // The page's summary as plain text.
+	WordCount   int
+	ReadingTime int // In minutes.
+}
+
+// ContentStatus lists the content files of a project with the publication
+// state each one has right now, and for pages their summary and length.
+func (e *Engine) ContentStatus(projectName string) ([]ContentFile, error) {
+	project, err := e.FindProjectByName(projectName)
+	if err != nil {
+		return nil, err
+	}
+	files, err := e.ListContentFiles(projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages are rendered the way a build would render them. A broken site
+	// configuration or theme is for the build to report; the list still
+	// shows every file.
+	var theme *Theme
+	var opts BuildOptions
+	if config, err := loadSiteConfig(project.Path); err == nil {
+		opts = config.apply(opts)
+		theme, _ = loadTheme(project.Path, config.Theme, opts)
+	}
+
+	now := time.Now()
+	contentDir := filepath.Join(project.Path, "content")
+	statuses := make([]ContentFile, 0, len(files))
+	for _, relPath := range files {
+		file := ContentFile{Path: relPath, Status: StatusFile}
+		if strings.HasSuffix(relPath, ".md") {
+			page, err := loadPage(filepath.Join(contentDir, relPath), filepath.ToSlash(relPath))
+			if err != nil {
+				file.Status = StatusInvalid
+				file.Error = err.Error()
+			} else {
+				file.Status = page.status(now)
+				file.Title = page.Title
+				summarizeFile(&file, page, theme, opts)
+			}
+		}
+		statuses = append(statuses, file)
+	}
+	return statuses, nil
+}
+
+// summarizeFile adds the summary and length of a page to its entry in the
+// content list, or marks it invalid if it cannot be rendered.
+func summarizeFile(file *ContentFile, page *Page, theme *Theme, opts BuildOptions) {
+	if theme == nil {
+		return
+	}
+	rendered, err := renderContent(page, theme, opts.markdownOptions())
+	if err != nil {
+		file.Status = StatusInvalid
+		file.Error = err.Error()
+		return
+	}
+	file.Summary = plainText(string(rendered.summary))
+	file.WordCount = rendered.wordCount
+	file.ReadingTime = readingTime(rendered.wordCount)
+}
+
+// describeStatus explains in a few words why a page is left out of a build.
+func describeStatus(page *Page, status string) string {
+	switch status {
+	case StatusDraft:
+		return "draft, skipped (build with drafts to include it)"
+	case StatusScheduled:
+		return fmt.Sprintf("publishDate %s is in the future, skipped", page.PublishDate.Format(time.RFC3339))
+	case StatusExpired:
+		return fmt.Sprintf("expired on %s, skipped", page.ExpiryDate.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// publishedOnly returns a filter for loadContent that keeps the pages a build
+// with opts renders at time now, reporting every page it leaves out.
+func publishedOnly(opts BuildOptions, now time.Time, events *buildEmitter) func(*Page) bool {
+	return func(page *Page) bool {
+		status := page.status(now)
+		if opts.publishes(status) {
+			return true
+		}
+		events.emit(EventFileSkipped, "content/"+page.File, describeStatus(page, status))
+		return false
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Builds never write into public/ directly. They render into a staging
+// directory next to it and only swap it in once everything succeeded, so a
+// failed build leaves the previously published site untouched.
+const (
+	stagingPattern = ".public-staging-*"
+	retiredPattern = ".public-old-*"
+)
+
+// liveBuildDirs holds the staging and retired directories of the builds
+// running in this process. They look just like the leftovers of a crashed
+// build, which prepareStaging removes, so it has to know to leave them alone.
+var liveBuildDirs = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// claimBuildDir marks dir as in use by a running build.
+func claimBuildDir(dir string) {
+	liveBuildDirs.Lock()
+	defer liveBuildDirs.Unlock()
+	liveBuildDirs.dirs[dir] = true
+}
+
+// releaseBuildDir marks dir as no longer in use.
+func releaseBuildDir(dir string) {
+	liveBuildDirs.Lock()
+	defer liveBuildDirs.Unlock()
+	delete(liveBuildDirs.dirs, dir)
+}
+
+// isLiveBuildDir reports whether a running build uses dir.
+func isLiveBuildDir(dir string) bool {
+	liveBuildDirs.Lock()
+	defer liveBuildDirs.Unlock()
+	return liveBuildDirs.dirs[dir]
+}
+
+// prepareStaging creates a fresh staging directory beside publicDir. When seed
+// is true the current public/ content is hard linked into it (or copied, where
+// links are not supported) so that an incremental build only has to touch
+// what changed. The caller releases the directory with releaseBuildDir once
+// it is done with it.
+func prepareStaging(publicDir string, seed bool) (string, error) {
+	parentDir := filepath.Dir(publicDir)
+	// Leftovers of builds that crashed halfway are of no use to anyone.
+	for _, pattern := range []string{stagingPattern, retiredPattern} {
+		leftovers, _ := filepath.Glob(filepath.Join(parentDir, pattern))
+		for _, dir := range leftovers {
+			if isLiveBuildDir(dir) {
+				continue
+			}
+			log.Printf("Removing leftover build directory: %s", dir)
+			os.RemoveAll(dir)
+		}
+	}
+
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	stagingDir, err := os.MkdirTemp(parentDir, stagingPattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	claimBuildDir(stagingDir)
+	// MkdirTemp creates the directory for its owner only, but it is going to
+	// be public/, which web servers running as other users must be able to read.
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(publicDir); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.Chmod(stagingDir, mode); err != nil {
+		os.RemoveAll(stagingDir)
+		releaseBuildDir(stagingDir)
+		return "", fmt.Errorf("failed to set permissions of staging directory: %w", err)
+	}
+
+	if !seed {
+		return stagingDir, nil
+	}
+	if _, err := os.Stat(publicDir); os.IsNotExist(err) {
+		return stagingDir, nil
+	}
+	if err := linkTree(publicDir, stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		releaseBuildDir(stagingDir)
+		return "", fmt.Errorf("failed to seed staging directory: %w", err)
+	}
+	return stagingDir, nil
+}
+
+// linkTree mirrors every file below src into dst using hard links, falling
+// back to a plain copy when the file system refuses to link.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dst, relPath)
+
+		if info.IsDir() {
+			return os.MkdirAll(destPath, 0755)
+		}
+		if err := os.Link(path, destPath); err == nil {
+			return nil
+		}
+		return copyFile(path, destPath)
+	})
+}
+
+// publishStaging replaces publicDir with stagingDir. The old public/ is first
+// moved aside and only deleted once the new one is in place; if the second
+// rename fails, the old directory is moved back.
+func publishStaging(stagingDir, publicDir string) error {
+	retiredDir := ""
+	if _, err := os.Stat(publicDir); err == nil {
+		retiredDir, err = os.MkdirTemp(filepath.Dir(publicDir), retiredPattern)
+		if err != nil {
+			return fmt.Errorf("failed to reserve directory for the previous output: %w", err)
+		}
+		claimBuildDir(retiredDir)
+		defer releaseBuildDir(retiredDir)
+		// MkdirTemp only gave us a unique name; rename needs it to be free.
+		os.Remove(retiredDir)
+		if err := os.Rename(publicDir, retiredDir); err != nil {
+			return fmt.Errorf("failed to move previous output aside: %w", err)
+		}
+	}
+
+	if err := os.Rename(stagingDir, publicDir); err != nil {
+		if retiredDir != "" {
+			os.Rename(retiredDir, publicDir)
+		}
+		return fmt.Errorf("failed to publish build output: %w", err)
+	}
+
+	if retiredDir != "" {
+		if err := os.RemoveAll(retiredDir); err != nil {
+			log.Printf("Could not remove previous output %s: %v", retiredDir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,606 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Shortcodes embed theme templates in Markdown content:
+//
+//	{{< youtube dQw4w9WgXcQ >}}
+//	{{< figure src="/img/cat.jpg" caption="A cat" >}}
+//	{{% callout type="warning" %}}Mind the **gap**.{{% /callout %}}
+//
+// A shortcode named "figure" is the template templates/shortcodes/figure.html
+// of the theme, looked up through the theme chain like every other template.
+// Parameters are either all positional or all named; values are bare words or
+// quoted strings. A shortcode with a closing tag gets what is between the tags
+// as .Inner: as it is with {{< >}}, rendered from Markdown with {{% %}}.
+// Shortcodes may be nested inside the inner content of others, and a
+// shortcode is written out literally with {{</* name */>}}. Tags in fenced
+// code blocks and inline code are shown as they are, so posts about
+// shortcodes can quote them.
+//
+// Shortcodes are expanded before the Markdown is converted; their output is
+// put in place afterwards, so the HTML they produce is never touched by the
+// Markdown converter.
+const shortcodesDir = "shortcodes"
+
+// Shortcode is what a shortcode template is executed with.
+type Shortcode struct {
+	Name       string
+	Params     map[string]string // The named parameters.
+	Positional []string          // The positional parameters.
+	Inner      template.HTML     // The content between the opening and the closing tag.
+	Page       *Page             // The page the shortcode is used in.
+	Parent     *Shortcode        // The shortcode this one is nested in, if any.
+}
+
+// Get returns a positional parameter by index or a named parameter by name,
+// or an empty string if there is no such parameter.
+func (s *Shortcode) Get(key interface{}) string {
+	switch k := key.(type) {
+	case int:
+		if k >= 0 && k < len(s.Positional) {
+			return s.Positional[k]
+		}
+	case string:
+		return s.Params[k]
+	}
+	return ""
+}
+
+// IsNamedParams reports whether the shortcode was given named parameters.
+func (s *Shortcode) IsNamedParams() bool {
+	return len(s.Params) > 0
+}
+
+// contentError is an error in a content file, pointing at the line it is on.
+type contentError struct {
+	file string // Relative to the project, e.g. "content/posts/hello.md".
+	line int
+	err  error
+}
+
+func (e *contentError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.file, e.line, e.err)
+}
+
+func (e *contentError) Unwrap() error {
+	return e.err
+}
+
+// shortcodeTag is an opening or closing shortcode tag in a page's body.
+type shortcodeTag struct {
+	name       string
+	params     map[string]string
+	positional []string
+	closing    bool // {{< /name >}}
+	selfClose  bool // {{< name />}}, which never takes inner content.
+	markdown   bool // Written with {{% %}}: the inner content is Markdown.
+	offset     int  // Byte offset of the tag in the body, for error lines.
+}
+
+// shortcodeNode is either plain text or a shortcode with its inner content.
+type shortcodeNode struct {
+	text     string
+	tag      *shortcodeTag
+	inner    []shortcodeNode
+	hasInner bool
+}
+
+// shortcodeParser turns a page's body into text and shortcode nodes.
+type shortcodeParser struct {
+	body   string
+	tokens []shortcodeNode // Flat: text and every tag, closing tags included.
+}
+
+// parseShortcodes splits body into text and shortcodes. Errors carry the
+// byte offset they occurred at.
+func parseShortcodes(body string) ([]shortcodeNode, error) {
+	p := &shortcodeParser{body: body}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	closers, err := p.matchClosers()
+	if err != nil {
+		return nil, err
+	}
+	return p.nodes(0, len(p.tokens), closers), nil
+}
+
+// offsetError is an error at a byte offset of a page's body.
+type offsetError struct {
+	offset int
+	err    error
+}
+
+func (e *offsetError) Error() string { return e.err.Error() }
+
+// lex finds every shortcode tag in the body outside of code.
+func (p *shortcodeParser) lex() error {
+	code := codeSpans(p.body)
+	text := 0
+	for pos := 0; pos < len(p.body); {
+		start := strings.Index(p.body[pos:], "{{")
+		if start < 0 {
+			break
+		}
+		start += pos
+		rest := p.body[start+2:]
+		if !strings.HasPrefix(rest, "<") && !strings.HasPrefix(rest, "%") {
+			pos = start + 2
+			continue
+		}
+		delim := rest[:1]
+		closeDelim := map[string]string{"<": ">}}", "%": "%}}"}[delim]
+
+		// {{</* name */>}} stands for the tag itself.
+		if strings.HasPrefix(rest[1:], "/*") {
+			end := strings.Index(rest, "*/"+closeDelim)
+			if end < 0 {
+				return &offsetError{offset: start, err: errors.New("unclosed shortcode comment")}
+			}
+			p.text(p.body[text:start])
+			p.text("{{" + delim + rest[3:end] + closeDelim)
+			pos = start + 2 + end + 2 + len(closeDelim)
+			text = pos
+			continue
+		}
+		if inSpans(code, start) {
+			pos = start + 2
+			continue
+		}
+
+		tag, length, err := lexTag(rest[1:], closeDelim)
+		if err != nil {
+			return &offsetError{offset: start, err: err}
+		}
+		tag.markdown = delim == "%"
+		tag.offset = start
+		p.text(p.body[text:start])
+		p.tokens = append(p.tokens, shortcodeNode{tag: tag})
+		pos = start + 3 + length
+		text = pos
+	}
+	p.text(p.body[text:])
+	return nil
+}
+
+// codeSpans returns the byte ranges of the fenced code blocks and the inline
+// code of a Markdown body, in order.
+func codeSpans(body string) [][2]int {
+	var spans [][2]int
+	inlineFrom := 0 // Where the text not yet searched for inline code starts.
+	for lineStart := 0; lineStart < len(body); {
+		lineEnd := lineEndAt(body, lineStart)
+		fence := openingFence(body[lineStart:lineEnd])
+		if fence == "" {
+			lineStart = lineEnd
+			continue
+		}
+		spans = append(spans, inlineCodeSpans(body, inlineFrom, lineStart)...)
+		// An unclosed fence runs to the end of the body, as in Markdown.
+		end := len(body)
+		for next := lineEnd; next < len(body); next = lineEndAt(body, next) {
+			if closesFence(body[next:lineEndAt(body, next)], fence) {
+				end = lineEndAt(body, next)
+				break
+			}
+		}
+		spans = append(spans, [2]int{lineStart, end})
+		lineStart, inlineFrom = end, end
+	}
+	return append(spans, inlineCodeSpans(body, inlineFrom, len(body))...)
+}
+
+// lineEndAt returns the offset after the line that starts at start, its
+// newline included.
+func lineEndAt(body string, start int) int {
+	if i := strings.IndexByte(body[start:], '\n'); i >= 0 {
+		return start + i + 1
+	}
+	return len(body)
+}
+
+// openingFence returns the ``` or ~~~ run a line opens a fenced code block
+// with, or "" if it opens none.
+func openingFence(line string) string {
+	line = trimIndent(line)
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return ""
+	}
+	n := len(line) - len(strings.TrimLeft(line, line[:1]))
+	if line[0] == '`' && strings.Contains(line[n:], "`") {
+		return "" // Inline code rather than a fence.
+	}
+	return line[:n]
+}
+
+// closesFence reports whether a line closes the fenced code block opened
+// with fence: a run of the same character at least as long, and nothing else.
+func closesFence(line, fence string) bool {
+	line = trimIndent(line)
+	rest := strings.TrimLeft(line, fence[:1])
+	return len(line)-len(rest) >= len(fence) && strings.TrimSpace(rest) == ""
+}
+
+// trimIndent removes the up to three spaces a fence may be indented by.
+func trimIndent(line string) string {
+	for i := 0; i < 3 && strings.HasPrefix(line, " "); i++ {
+		line = line[1:]
+	}
+	return line
+}
+
+// inlineCodeSpans returns the ranges of inline code between from and to: a
+// run of backticks up to the next run of the same length.
+func inlineCodeSpans(body string, from, to int) [][2]int {
+	var spans [][2]int
+	for pos := from; pos < to; {
+		i := strings.IndexByte(body[pos:to], '`')
+		if i < 0 {
+			break
+		}
+		start := pos + i
+		n := len(body[start:to]) - len(strings.TrimLeft(body[start:to], "`"))
+		end := -1
+		for next := start + n; next < to; {
+			j := strings.IndexByte(body[next:to], '`')
+			if j < 0 {
+				break
+			}
+			runStart := next + j
+			run := len(body[runStart:to]) - len(strings.TrimLeft(body[runStart:to], "`"))
+			if run == n {
+				end = runStart + run
+				break
+			}
+			next = runStart + run
+		}
+		if end < 0 {
+			// A run without a closing run is just backticks.
+			pos = start + n
+			continue
+		}
+		spans = append(spans, [2]int{start, end})
+		pos = end
+	}
+	return spans
+}
+
+// inSpans reports whether offset lies in one of the ordered spans.
+func inSpans(spans [][2]int, offset int) bool {
+	i := sort.Search(len(spans), func(i int) bool { return spans[i][1] > offset })
+	return i < len(spans) && spans[i][0] <= offset
+}
+
+func (p *shortcodeParser) text(s string) {
+	if s != "" {
+		p.tokens = append(p.tokens, shortcodeNode{text: s})
+	}
+}
+
+// matchClosers pairs every opening tag with its closing tag in one pass and
+// returns, for each token, the index of its closing tag or -1. A closing tag
+// closes the innermost open tag of its name; the tags opened after that one
+// have no closing tag and so no inner content. A closing tag without an open
+// tag of its name is an error.
+func (p *shortcodeParser) matchClosers() ([]int, error) {
+	closers := make([]int, len(p.tokens))
+	var open []int // Indices of the opening tags not closed yet.
+	for i, token := range p.tokens {
+		closers[i] = -1
+		switch {
+		case token.tag == nil || token.tag.selfClose:
+		case !token.tag.closing:
+			open = append(open, i)
+		default:
+			k := len(open) - 1
+			for k >= 0 && p.tokens[open[k]].tag.name != token.tag.name {
+				k--
+			}
+			if k < 0 {
+				return nil, &offsetError{offset: token.tag.offset, err: fmt.Errorf("closing shortcode %s has no opening tag", token.tag.name)}
+			}
+			closers[open[k]] = i
+			open = open[:k]
+		}
+	}
+	return closers, nil
+}
+
+// nodes builds the tree from the tokens from i up to end, using the closing
+// tags found by matchClosers.
+func (p *shortcodeParser) nodes(i, end int, closers []int) []shortcodeNode {
+	var nodes []shortcodeNode
+	for i < end {
+		token := p.tokens[i]
+		if closer := closers[i]; closer >= 0 {
+			nodes = append(nodes, shortcodeNode{tag: token.tag, inner: p.nodes(i+1, closer, closers), hasInner: true})
+			i = closer + 1
+			continue
+		}
+		nodes = append(nodes, token)
+		i++
+	}
+	return nodes
+}
+
+var shortcodeNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*`)
+
+// lexTag parses a tag after its "{{<" or "{{%" up to closeDelim and returns
+// it with the number of bytes it took, closing delimiter included.
+func lexTag(s, closeDelim string) (*shortcodeTag, int, error) {
+	tag := &shortcodeTag{}
+	pos := skipSpace(s, 0)
+	if strings.HasPrefix(s[pos:], "/") {
+		tag.closing = true
+		pos = skipSpace(s, pos+1)
+	}
+	tag.name = shortcodeNamePattern.FindString(s[pos:])
+	if tag.name == "" {
+		return nil, 0, errors.New("expected a shortcode name")
+	}
+	pos += len(tag.name)
+
+	for {
+		pos = skipSpace(s, pos)
+		switch {
+		case pos >= len(s):
+			return nil, 0, fmt.Errorf("shortcode %s is not closed with %s", tag.name, closeDelim)
+		case strings.HasPrefix(s[pos:], closeDelim):
+			if tag.closing && (len(tag.params) > 0 || len(tag.positional) > 0) {
+				return nil, 0, fmt.Errorf("closing shortcode %s cannot have parameters", tag.name)
+			}
+			return tag, pos + len(closeDelim), nil
+		case strings.HasPrefix(s[pos:], "/") && strings.HasPrefix(s[skipSpace(s, pos+1):], closeDelim):
+			tag.selfClose = true
+			pos = skipSpace(s, pos+1)
+			continue
+		}
+		if tag.selfClose {
+			return nil, 0, fmt.Errorf("shortcode %s: unexpected text after /", tag.name)
+		}
+
+		// key=value or a positional value.
+		key := ""
+		if name := shortcodeNamePattern.FindString(s[pos:]); name != "" && strings.HasPrefix(s[pos+len(name):], "=") {
+			key = name
+			pos += len(name) + 1
+		}
+		value, length, err := lexValue(s[pos:], closeDelim)
+		if err != nil {
+			return nil, 0, fmt.Errorf("shortcode %s: %w", tag.name, err)
+		}
+		pos += length
+
+		if key != "" {
+			if len(tag.positional) > 0 {
+				return nil, 0, fmt.Errorf("shortcode %s mixes named and positional parameters", tag.name)
+			}
+			if tag.params == nil {
+				tag.params = make(map[string]string)
+			}
+			tag.params[key] = value
+		} else {
+			if len(tag.params) > 0 {
+				return nil, 0, fmt.Errorf("shortcode %s mixes named and positional parameters", tag.name)
+			}
+			tag.positional = append(tag.positional, value)
+		}
+	}
+}
+
+// lexValue reads a parameter value: a "quoted" or `raw` string, or a word.
+func lexValue(s, closeDelim string) (string, int, error) {
+	if s == "" {
+		return "", 0, errors.New("missing parameter value")
+	}
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, i + 1, nil
+			}
+		}
+		return "", 0, errors.New("unterminated string")
+	case '`':
+		end := strings.IndexByte(s[1:], '`')
+		if end < 0 {
+			return "", 0, errors.New("unterminated string")
+		}
+		return s[1 : end+1], end + 2, nil
+	}
+	end := 0
+	for end < len(s) && !unicode.IsSpace(rune(s[end])) && !strings.HasPrefix(s[end:], closeDelim) {
+		end++
+	}
+	if end == 0 {
+		return "", 0, errors.New("missing parameter value")
+	}
+	return s[:end], end, nil
+}
+
+func skipSpace(s string, pos int) int {
+	for pos < len(s) && unicode.IsSpace(rune(s[pos])) {
+		pos++
+	}
+	return pos
+}
+
+// shortcodePlaceholder stands in for a shortcode's output while the Markdown
+// is converted. It is plain letters and digits, which Markdown leaves alone.
+const shortcodePlaceholder = "GOSSGSHORTCODE%dX"
+
+var shortcodePlaceholderPattern = regexp.MustCompile(`<p>GOSSGSHORTCODE(\d+)X</p>\n?|GOSSGSHORTCODE(\d+)X`)
+
+// shortcodeRenderer expands the shortcodes of one page.
+type shortcodeRenderer struct {
+	theme   *Theme
+	opts    markdownOptions
+	page    *Page
+	outputs []string
+}
+
+// renderContent converts a page's Markdown to HTML, expanding its shortcodes
+// with the templates of theme, and collects its headings and summary.
+func renderContent(page *Page, theme *Theme, opts markdownOptions) (renderedContent, error) {
+	markdown := page.body
+	r := &shortcodeRenderer{theme: theme, opts: opts, page: page}
+	if bytes.Contains(markdown, []byte("{{<")) || bytes.Contains(markdown, []byte("{{%")) {
+		nodes, err := parseShortcodes(string(page.body))
+		if err != nil {
+			var offsetErr *offsetError
+			errors.As(err, &offsetErr)
+			return renderedContent{}, page.errorAt(offsetErr.offset, offsetErr.err)
+		}
+		expanded, err := r.expand(nodes, nil)
+		if err != nil {
+			return renderedContent{}, err
+		}
+		markdown = []byte(expanded)
+	}
+
+	html, headings, err := page.markdownToHTML(markdown, opts, r.headingText)
+	if err != nil {
+		return renderedContent{}, err
+	}
+	rendered := renderedContent{
+		html:     template.HTML(r.resolve(string(html))),
+		headings: nestHeadings(headings),
+		toc:      tableOfContents(headings, opts.headings),
+	}
+	if err := page.summarize(&rendered, opts); err != nil {
+		return renderedContent{}, err
+	}
+	return rendered, nil
+}
+
+// markdownToHTML converts Markdown of the page, which may be its body or the
+// inner content of a shortcode, and locates the code block a broken fence
+// info string belongs to. headingText is passed on to renderMarkdown.
+func (p *Page) markdownToHTML(markdown []byte, opts markdownOptions, headingText func(string) string) (template.HTML, []*Heading, error) {
+	html, headings, err := renderMarkdown(markdown, opts, headingText)
+	var fenceErr *fenceError
+	if errors.As(err, &fenceErr) {
+		return "", nil, p.errorAt(max(bytes.Index(p.body, []byte(fenceErr.info)), 0), err)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("content/%s: %w", p.File, err)
+	}
+	return html, headings, nil
+}
+
+// expand executes the shortcodes among nodes and returns the text with a
+// placeholder for each of them.
+func (r *shortcodeRenderer) expand(nodes []shortcodeNode, parent *Shortcode) (string, error) {
+	var b strings.Builder
+	for _, node := range nodes {
+		if node.tag == nil {
+			b.WriteString(node.text)
+			continue
+		}
+		output, err := r.execute(node, parent)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, shortcodePlaceholder, len(r.outputs))
+		r.outputs = append(r.outputs, output)
+	}
+	return b.String(), nil
+}
+
+// execute runs the template of a single shortcode.
+func (r *shortcodeRenderer) execute(node shortcodeNode, parent *Shortcode) (string, error) {
+	tag := node.tag
+	tmpl, ok := r.theme.shortcodes[tag.name]
+	if !ok {
+		return "", r.page.errorAt(tag.offset, fmt.Errorf("unknown shortcode %s, there is no %s/%s.html in the theme", tag.name, shortcodesDir, tag.name))
+	}
+
+	sc := &Shortcode{
+		Name:       tag.name,
+		Params:     tag.params,
+		Positional: tag.positional,
+		Page:       r.page,
+		Parent:     parent,
+	}
+	if node.hasInner {
+		inner, err := r.expand(node.inner, sc)
+		if err != nil {
+			return "", err
+		}
+		if tag.markdown {
+			html, _, err := r.page.markdownToHTML([]byte(inner), r.opts, r.headingText)
+			if err != nil {
+				return "", err
+			}
+			inner = string(html)
+		}
+		sc.Inner = template.HTML(inner)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, sc); err != nil {
+		// Errors of the template point at the template file and at the
+		// shortcode that ran it.
+		var tmplErr *TemplateError
+		if errors.As(r.theme.locateError(err), &tmplErr) {
+			if tmplErr.Content == "" {
+				tmplErr.Page, tmplErr.Content = r.page.RelPermalink, "content/"+r.page.File
+				tmplErr.ContentLine = r.page.lineAt(tag.offset)
+			}
+			return "", tmplErr
+		}
+		return "", r.page.errorAt(tag.offset, fmt.Errorf("shortcode %s: %w", tag.name, err))
+	}
+	return b.String(), nil
+}
+
+// resolve puts the output of every shortcode in place of its placeholder.
+// A placeholder that Markdown made a paragraph of its own loses the <p>.
+func (r *shortcodeRenderer) resolve(html string) string {
+	return shortcodePlaceholderPattern.ReplaceAllStringFunc(html, func(match string) string {
+		groups := shortcodePlaceholderPattern.FindStringSubmatch(match)
+		index, _ := strconv.Atoi(groups[1] + groups[2])
+		if index >= len(r.outputs) {
+			return match
+		}
+		return r.resolve(r.outputs[index])
+	})
+}
+
+// headingText puts the plain text of their output in place of the
+// placeholders of shortcodes in a heading, which is what its id, title and
+// entry in the table of contents are made from.
+func (r *shortcodeRenderer) headingText(text string) string {
+	if !shortcodePlaceholderPattern.MatchString(text) {
+		return text
+	}
+	return plainText(r.resolve(text))
+}
+
+// errorAt locates an error at a byte offset of the page's body.
+func (p *Page) errorAt(offset int, err error) error {
+	return &contentError{file: "content/" + p.File, line: p.lineAt(offset), err: err}
+}
+
+// lineAt returns the line of the content file a byte offset of the body is on.
+func (p *Page) lineAt(offset int) int {
+	return p.bodyLine + strings.Count(string(p.body[:offset]), "\n")
+}
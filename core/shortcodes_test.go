@@ -0,0 +1,117 @@
+package core
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseShortcodesStandalone(t *testing.T) {
+	body := strings.Repeat("{{< figure src=\"a.jpg\" >}}\n", 100)
+
+	done := make(chan struct{})
+	var nodes []shortcodeNode
+	var err error
+	go func() {
+		nodes, err = parseShortcodes(body)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parsing 100 standalone shortcodes did not finish in 5s")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := 0
+	for _, node := range nodes {
+		if node.tag == nil {
+			continue
+		}
+		tags++
+		if node.hasInner {
+			t.Errorf("standalone shortcode at offset %d has inner content", node.tag.offset)
+		}
+	}
+	if tags != 100 {
+		t.Errorf("got %d shortcodes, want 100", tags)
+	}
+}
+
+func TestParseShortcodesNested(t *testing.T) {
+	body := `{{% callout %}}a {{< figure >}} b {{< youtube x >}}c{{< /youtube >}}{{% /callout %}} d`
+	nodes, err := parseShortcodes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].tag == nil || nodes[0].tag.name != "callout" || !nodes[0].hasInner {
+		t.Fatalf("got %+v, want callout with inner content followed by text", nodes)
+	}
+
+	var inner []string
+	for _, node := range nodes[0].inner {
+		if node.tag == nil {
+			inner = append(inner, "text")
+			continue
+		}
+		name := node.tag.name
+		if node.hasInner {
+			name += "[]"
+		}
+		inner = append(inner, name)
+	}
+	if got, want := strings.Join(inner, " "), "text figure text youtube[]"; got != want {
+		t.Errorf("inner content is %q, want %q", got, want)
+	}
+}
+
+func TestParseShortcodesStrayClosingTag(t *testing.T) {
+	body := "{{< figure >}}\n{{< /youtube >}}"
+	_, err := parseShortcodes(body)
+	var offsetErr *offsetError
+	if !errors.As(err, &offsetErr) {
+		t.Fatalf("got %v, want an offsetError", err)
+	}
+	if want := strings.Index(body, "{{< /youtube"); offsetErr.offset != want {
+		t.Errorf("error at offset %d, want %d", offsetErr.offset, want)
+	}
+}
+
+func TestParseShortcodesInCode(t *testing.T) {
+	body := "Use `{{< figure src=\"a\" >}}` like this:\n\n" +
+		"```markdown\n{{< youtube x >}}\n{{% callout %}}unclosed\n```\n\n" +
+		"~~~~\n{{< figure >}}\n~~~\nstill code\n~~~~\n\n" +
+		"``{{< figure >}} with ` inside`` and {{< figure >}}\n\n" +
+		"```\n{{</* youtube x */>}}\n```\n"
+	nodes, err := parseShortcodes(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text strings.Builder
+	tags := 0
+	for _, node := range nodes {
+		if node.tag != nil {
+			tags++
+			continue
+		}
+		text.WriteString(node.text)
+	}
+	if tags != 1 {
+		t.Errorf("got %d shortcodes, want only the one outside of code", tags)
+	}
+	for _, want := range []string{
+		"`{{< figure src=\"a\" >}}`",
+		"{{< youtube x >}}\n{{% callout %}}unclosed",
+		"{{< figure >}}\n~~~\nstill code",
+		"``{{< figure >}} with ` inside``",
+		"```\n{{< youtube x >}}\n```", // The escape still works in code.
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text lacks %q:\n%s", want, text.String())
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// siteConfigFiles are the names a project's site configuration may have, in
+// the order they are looked for. A project uses at most one of them.
+var siteConfigFiles = []string{"site.yaml", "site.yml", "site.toml", "site.json"}
+
+// defaultSiteConfig is written into new projects. Everything but the title
+// is optional.
+const defaultSiteConfig = `# Site configuration, see the README for every option.
+title: %q
+# The absolute URL the site is published under, used for feeds, the sitemap
+# and every .Permalink.
+baseURL: ""
+language: en
+author: ""
+theme: default
+
+# URLs: patterns per section such as "posts: /:year/:month/:slug/", and
+# whether pages are written as <name>/index.html instead of <name>.html.
+permalinks: {}
+prettyURLs: false
+
+# Build options.
+paginate: 10
+taxonomies: [tags, categories]
+feeds:
+  limit: 20
+  fullContent: false
+sitemap:
+  disable: false
+# Pages list their old URLs under "aliases:"; besides a redirect page for each,
+# a Netlify _redirects file and an nginx map can be written.
+redirects:
+  netlify: false
+  nginx: false
+# Code blocks are highlighted with a chroma style, whose stylesheet is
+# written to css/syntax.css.
+highlight:
+  style: github
+  lineNumbers: false
+# The heading levels .TableOfContents lists, and whether headings get a link
+# to themselves.
+headings:
+  tocStartLevel: 2
+  tocEndLevel: 3
+  anchors: false
+# The number of words of a summary taken from the start of a page, for pages
+# without a <!--more--> line or summary front matter.
+summaryLength: 70
+
+# Navigation menus, available to templates as .Site.Menus.<name>. Pages add
+# themselves with "menu: main" in their front matter.
+menus:
+  main:
+    - name: Home
+      url: /
+      weight: 1
+
+# Anything below params is available to templates as .Site.Params.
+params: {}
+`
+
+// SiteConfig is the content of a project's site.yaml (or site.toml, site.json).
+// Options set for a single build in BuildOptions take precedence over it.
+type SiteConfig struct {
+	BaseURL  string `yaml:"baseURL" json:"baseURL" toml:"baseURL"`
+	Title    string `yaml:"title" json:"title" toml:"title"`
+	Language string `yaml:"language" json:"language" toml:"language"` // A language tag such as "en" or "de-AT".
+	Author   string `yaml:"author" json:"author" toml:"author"`       // The default author of pages without one.
+	Theme    string `yaml:"theme" json:"theme" toml:"theme"`          // A directory below themes/.
+
+	// Permalinks maps a section to the URL pattern of its pages, e.g.
+	// "posts" to "/:year/:month/:slug/". PrettyURLs writes every page as
+	// <name>/index.html, so its URL ends in a slash instead of ".html".
+	Permalinks map[string]string `yaml:"permalinks" json:"permalinks" toml:"permalinks"`
+	PrettyURLs bool              `yaml:"prettyURLs" json:"prettyURLs" toml:"prettyURLs"`
+
+	Paginate   int              `yaml:"paginate" json:"paginate" toml:"paginate"`
+	Taxonomies []string         `yaml:"taxonomies" json:"taxonomies" toml:"taxonomies"`
+	Feeds      FeedOptions      `yaml:"feeds" json:"feeds" toml:"feeds"`
+	Sitemap    SitemapOptions   `yaml:"sitemap" json:"sitemap" toml:"sitemap"`
+	Redirects  RedirectOptions  `yaml:"redirects" json:"redirects" toml:"redirects"`
+	Highlight  HighlightOptions `yaml:"highlight" json:"highlight" toml:"highlight"`
+	Headings   HeadingOptions   `yaml:"headings" json:"headings" toml:"headings"`
+
+	// SummaryLength is the number of words of .Summary for pages without a
+	// <!--more--> divider or summary front matter.
+	SummaryLength int `yaml:"summaryLength" json:"summaryLength" toml:"summaryLength"`
+
+	// Menus are the site's navigation menus by name, see MenuEntryConfig.
+	// Pages add themselves with `menu` front matter.
+	Menus map[string][]MenuEntryConfig `yaml:"menus" json:"menus" toml:"menus"`
+
+	Params map[string]interface{} `yaml:"params" json:"params" toml:"params"`
+
+	file string // The file the configuration was read from, empty for the defaults.
+}
+
+// Site is what templates see as .Site: the site wide settings every page shares.
+type Site struct {
+	Title    string
+	BaseURL  string
+	Language string
+	Author   string
+	Params   map[string]interface{}
+	Menus    map[string]Menu // The menus of site.yaml and front matter, e.g. .Site.Menus.main.
+
+	// Data holds the project's data files, see dataDir. It is left out of
+	// the site's fingerprint; only pages whose templates use it depend on it.
+	Data map[string]interface{} `json:"-"`
+
+	// Pages lists every page of the site: the regular pages, sorted like
+	// a section's, then the list pages. RegularPages are only the former.
+	// Like Data, they are left out of the site's fingerprint, which would
+	// otherwise contain every page, and they link back to the site.
+	Pages        []*Page `json:"-"`
+	RegularPages []*Page `json:"-"`
+}
+
+// File returns the path of the configuration file, relative to the project,
+// or an empty string when the project has none.
+func (c *SiteConfig) File() string {
+	return c.file
+}
+
+// SiteConfig loads and validates the site configuration of a project.
+func (e *Engine) SiteConfig(projectName string) (*SiteConfig, error) {
+	project, err := e.FindProjectByName(projectName)
+	if err != nil {
+		return nil, err
+	}
+	return loadSiteConfig(project.Path)
+}
+
+// loadSiteConfig reads the site configuration from a project root. Projects
+// without one get the defaults, so older projects keep building.
+func loadSiteConfig(projectPath string) (*SiteConfig, error) {
+	config := &SiteConfig{}
+
+	var found []string
+	for _, name := range siteConfigFiles {
+		if _, err := os.Stat(filepath.Join(projectPath, name)); err == nil {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return config.withDefaults(), nil
+	case 1:
+		config.file = found[0]
+	default:
+		return nil, fmt.Errorf("found both %s and %s, a project can only have one site configuration", found[0], found[1])
+	}
+
+	data, err := os.ReadFile(filepath.Join(projectPath, config.file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", config.file, err)
+	}
+	if err := decodeSiteConfig(config.file, data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", config.file, err)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s:\n%w", config.file, err)
+	}
+	return config.withDefaults(), nil
+}
+
+// decodeSiteConfig decodes data according to the file's extension. Unknown
+// keys are rejected, so that typos do not go unnoticed.
+func decodeSiteConfig(name string, data []byte, config *SiteConfig) error {
+	switch filepath.Ext(name) {
+	case ".toml":
+		meta, err := toml.Decode(string(data), config)
+		if err != nil {
+			return err
+		}
+		for _, key := range meta.Undecoded() {
+			// Parameters are free form; everything else has to be a known option.
+			if key[0] != "params" {
+				return fmt.Errorf("unknown option %q", key.String())
+			}
+		}
+		return nil
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		return dec.Decode(config)
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty file is a valid, if pointless, configuration.
+		err := dec.Decode(config)
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			// Say "unknown option" like the other formats instead of naming Go types.
+			for i, msg := range typeErr.Errors {
+				typeErr.Errors[i] = yamlUnknownFieldPattern.ReplaceAllString(msg, `unknown option "$1"`)
+			}
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	}
+}
+
+var (
+	yamlUnknownFieldPattern = regexp.MustCompile(`field (\S+) not found in type \S+`)
+	languageTagPattern      = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+	taxonomyPattern         = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+// validate checks the values of the configuration and reports every problem at once.
+func (c *SiteConfig) validate() error {
+	var errs []error
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("baseURL %q must be an absolute http or https URL, e.g. https://example.com/", c.BaseURL))
+		}
+	}
+	if c.Language != "" && !languageTagPattern.MatchString(c.Language) {
+		errs = append(errs, fmt.Errorf("language %q is not a language tag such as \"en\" or \"de-AT\"", c.Language))
+	}
+	if c.Theme != "" && (c.Theme != filepath.Base(c.Theme) || strings.HasPrefix(c.Theme, ".")) {
+		errs = append(errs, fmt.Errorf("theme %q must be the name of a directory in themes/", c.Theme))
+	}
+	for _, section := range sortedKeys(c.Permalinks) {
+		if err := validatePermalink(c.Permalinks[section]); err != nil {
+			errs = append(errs, fmt.Errorf("permalink %q of section %q: %w", c.Permalinks[section], section, err))
+		}
+	}
+	if c.Paginate < 0 {
+		errs = append(errs, fmt.Errorf("paginate must not be negative, got %d", c.Paginate))
+	}
+	seen := make(map[string]bool)
+	for _, taxonomy := range c.Taxonomies {
+		if !taxonomyPattern.MatchString(taxonomy) {
+			errs = append(errs, fmt.Errorf("taxonomy %q must be a lower case front matter key such as \"tags\"", taxonomy))
+		} else if seen[taxonomy] {
+			errs = append(errs, fmt.Errorf("taxonomy %q is listed twice", taxonomy))
+		}
+		seen[taxonomy] = true
+	}
+	if c.Feeds.Limit < 0 {
+		errs = append(errs, fmt.Errorf("feeds.limit must not be negative, got %d", c.Feeds.Limit))
+	}
+	if c.SummaryLength < 0 {
+		errs = append(errs, fmt.Errorf("summaryLength must not be negative, got %d", c.SummaryLength))
+	}
+	start, end := c.Headings.TOCStartLevel, c.Headings.TOCEndLevel
+	if start < 0 || start > 6 {
+		errs = append(errs, fmt.Errorf("headings.tocStartLevel must be a heading level from 1 to 6, got %d", start))
+	}
+	if end < 0 || end > 6 {
+		errs = append(errs, fmt.Errorf("headings.tocEndLevel must be a heading level from 1 to 6, got %d", end))
+	}
+	if start > 0 && end > 0 && start > end {
+		errs = append(errs, fmt.Errorf("headings.tocStartLevel %d must not be greater than headings.tocEndLevel %d", start, end))
+	}
+	if c.Highlight.Style != "" && !validHighlightStyle(c.Highlight.Style) {
+		errs = append(errs, fmt.Errorf("highlight.style %q is not a known style such as \"github\", \"monokai\" or \"dracula\"", c.Highlight.Style))
+	}
+	errs = append(errs, validateMenus(c.Menus)...)
+	return errors.Join(errs...)
+}
+
+// withDefaults fills in the options the configuration leaves out.
+func (c *SiteConfig) withDefaults() *SiteConfig {
+	if c.Language == "" {
+		c.Language = "en"
+	}
+	if c.Theme == "" {
+		c.Theme = defaultThemeName
+	}
+	if c.BaseURL != "" && !strings.HasSuffix(c.BaseURL, "/") {
+		c.BaseURL += "/"
+	}
+	if c.Params == nil {
+		c.Params = make(map[string]interface{})
+	}
+	if c.Highlight.Style == "" {
+		c.Highlight.Style = defaultHighlightStyle
+	}
+	if c.SummaryLength == 0 {
+		c.SummaryLength = defaultSummaryLength
+	}
+	if c.Headings.TOCStartLevel == 0 {
+		c.Headings.TOCStartLevel = defaultTOCStartLevel
+	}
+	if c.Headings.TOCEndLevel == 0 {
+		c.Headings.TOCEndLevel = max(defaultTOCEndLevel, c.Headings.TOCStartLevel)
+	}
+	return c
+}
+
+// apply merges the configuration into the options of a single build. Options
+// set for the build win; the others come from the configuration.
+func (c *SiteConfig) apply(opts BuildOptions) BuildOptions {
+	if opts.BaseURL == "" {
+		opts.BaseURL = c.BaseURL
+	}
+	if opts.PageSize < 1 {
+		opts.PageSize = c.Paginate
+	}
+	if len(opts.Taxonomies) == 0 {
+		opts.Taxonomies = c.Taxonomies
+	}
+	if opts.Feeds.Limit < 1 {
+		opts.Feeds.Limit = c.Feeds.Limit
+	}
+	opts.Feeds.FullContent = opts.Feeds.FullContent || c.Feeds.FullContent
+	opts.Feeds.Disable = opts.Feeds.Disable || c.Feeds.Disable
+	opts.Sitemap.Disable = opts.Sitemap.Disable || c.Sitemap.Disable
+	opts.Redirects.Netlify = opts.Redirects.Netlify || c.Redirects.Netlify
+	opts.Redirects.Nginx = opts.Redirects.Nginx || c.Redirects.Nginx
+	if opts.Highlight.Style == "" {
+		opts.Highlight.Style = c.Highlight.Style
+	}
+	opts.Highlight.Disable = opts.Highlight.Disable || c.Highlight.Disable
+	opts.Highlight.LineNumbers = opts.Highlight.LineNumbers || c.Highlight.LineNumbers
+	if opts.Headings.TOCStartLevel < 1 {
+		opts.Headings.TOCStartLevel = c.Headings.TOCStartLevel
+	}
+	if opts.Headings.TOCEndLevel < 1 {
+		opts.Headings.TOCEndLevel = c.Headings.TOCEndLevel
+	}
+	opts.Headings.Anchors = opts.Headings.Anchors || c.Headings.Anchors
+	if opts.SummaryLength < 1 {
+		opts.SummaryLength = c.SummaryLength
+	}
+	return opts
+}
+
+// site returns the template view of the configuration for a build with the
+// given options. title is the configured title or a fallback for it.
+func (c *SiteConfig) site(opts BuildOptions, title string) *Site {
+	return &Site{
+		Title:    title,
+		BaseURL:  opts.BaseURL,
+		Language: c.Language,
+		Author:   c.Author,
+		Params:   c.Params,
+	}
+}
+
+// writeDefaultSiteConfig scaffolds site.yaml in a new project, unless the
+// project already has a site configuration.
+func writeDefaultSiteConfig(projectPath, title string) error {
+	for _, name := range siteConfigFiles {
+		if _, err := os.Stat(filepath.Join(projectPath, name)); err == nil {
+			return nil
+		}
+	}
+	path := filepath.Join(projectPath, siteConfigFiles[0])
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(defaultSiteConfig, title)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sitemapMaxURLs is the most URLs a single sitemap file may list according to
+// the sitemaps.org protocol. Larger sites get a sitemap index pointing to
+// sitemap1.xml, sitemap2.xml and so on.
+const (
+	sitemapFile    = "sitemap.xml"
+	robotsFile     = "robots.txt"
+	sitemapMaxURLs = 50000
+	sitemapXMLNS   = "http://www.sitemaps.org/schemas/sitemap/0.9"
+)
+
+// sitemapChangeFreqs are the values the protocol allows for <changefreq>.
+var sitemapChangeFreqs = map[string]bool{
+	"always": true, "hourly": true, "daily": true, "weekly": true,
+	"monthly": true, "yearly": true, "never": true,
+}
+
+// SitemapOptions controls sitemap.xml and robots.txt.
+//
+// A page can leave the sitemap with `sitemap: false` in its front matter, or
+// tune its entry with
+//
+//	sitemap:
+//	  priority: 0.8
+//	  changefreq: weekly
+type SitemapOptions struct {
+	// Disable turns off both sitemap.xml and robots.txt.
+	Disable bool `yaml:"disable" json:"disable" toml:"disable"`
+}
+
+// sitemapURL is one <url> entry of a sitemap.
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	Lastmod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name          `xml:"sitemapindex"`
+	XMLNS    string            `xml:"xmlns,attr"`
+	Sitemaps []sitemapIndexRef `xml:"sitemap"`
+}
+
+type sitemapIndexRef struct {
+	Loc     string `xml:"loc"`
+	Lastmod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapEntry builds the sitemap entry of a rendered page and reports whether
+// the page wants to be listed at all. Invalid overrides are reported as
+// warnings and ignored.
+func (s *buildState) sitemapEntry(page *Page) (sitemapURL, bool) {
+	entry := sitemapURL{Loc: page.Permalink}
+	if lastmod := pageLastmod(page); !lastmod.IsZero() {
+		entry.Lastmod = lastmod.UTC().Format(time.RFC3339)
+	}
+
+	source := "content/" + page.File
+	switch override := page.FrontMatter["sitemap"].(type) {
+	case nil:
+	case bool:
+		return entry, override
+	case map[string]interface{}:
+		if disable, _ := override["disable"].(bool); disable {
+			return entry, false
+		}
+		if value, ok := override["changefreq"]; ok {
+			if freq, _ := value.(string); sitemapChangeFreqs[freq] {
+				entry.ChangeFreq = freq
+			} else {
+				s.events.emit(EventWarning, source, fmt.Sprintf("ignoring invalid sitemap changefreq %v", value))
+			}
+		}
+		if value, ok := override["priority"]; ok {
+			if priority, ok := sitemapPriority(value); ok {
+				entry.Priority = strconv.FormatFloat(priority, 'f', 1, 64)
+			} else {
+				s.events.emit(EventWarning, source, fmt.Sprintf("ignoring invalid sitemap priority %v, expected 0.0 to 1.0", value))
+			}
+		}
+	default:
+		s.events.emit(EventWarning, source, "ignoring invalid sitemap front matter, expected false or a map")
+	}
+	return entry, true
+}
+
+// sitemapPriority reads a priority between 0 and 1.
+func sitemapPriority(value interface{}) (float64, bool) {
+	var priority float64
+	switch v := value.(type) {
+	case float64:
+		priority = v
+	case int:
+		priority = float64(v)
+	default:
+		return 0, false
+	}
+	return priority, priority >= 0 && priority <= 1
+}
+
+// pageLastmod returns when a page last changed: the `lastmod` or `date` front
+// matter, else the modification time of its source file. A list page without
+// an _index.md changed when the newest page it lists did.
+func pageLastmod(page *Page) time.Time {
+	if !page.Lastmod.IsZero() {
+		return page.Lastmod
+	}
+	if !page.modTime.IsZero() {
+		return page.modTime
+	}
+	var newest time.Time
+	for _, child := range page.regularPages {
+		if lastmod := pageLastmod(child); lastmod.After(newest) {
+			newest = lastmod
+		}
+	}
+	return newest
+}
+
+// planSitemap schedules sitemap.xml (split up with an index when needed) and
+// robots.txt for every page this build renders. A robots.txt of the project's
+// own, from content/ or the theme's static files, is left alone. Sitemaps
+// must list absolute URLs, so without a base URL there is none, and
+// robots.txt does not point to one.
+func planSitemap(state *buildState) error {
+	if state.opts.Sitemap.Disable {
+		return nil
+	}
+	if state.opts.BaseURL == "" {
+		state.events.emit(EventWarning, "", "no base URL configured, skipping sitemap.xml, which needs absolute URLs")
+		return planRobots(state, "")
+	}
+
+	var urls []sitemapURL
+	for _, page := range state.sitemap {
+		if entry, ok := state.sitemapEntry(page); ok {
+			urls = append(urls, entry)
+		}
+	}
+	sort.Slice(urls, func(i, j int) bool { return urls[i].Loc < urls[j].Loc })
+
+	// The sitemap only changes with its entries, so they are its fingerprint.
+	parts := []string{"sitemap"}
+	for _, u := range urls {
+		parts = append(parts, u.Loc, u.Lastmod, u.ChangeFreq, u.Priority)
+	}
+	fp := fingerprint(parts...)
+
+	if len(urls) <= sitemapMaxURLs {
+		if _, err := state.planOutput(sitemapFile, "sitemap", fp, func(destPath string) error {
+			return writeXMLFile(destPath, sitemapURLSet{XMLNS: sitemapXMLNS, URLs: urls})
+		}); err != nil {
+			return err
+		}
+	} else {
+		index := sitemapIndex{XMLNS: sitemapXMLNS}
+		for start, n := 0, 1; start < len(urls); start, n = start+sitemapMaxURLs, n+1 {
+			chunk := urls[start:min(start+sitemapMaxURLs, len(urls))]
+			name := fmt.Sprintf("sitemap%d.xml", n)
+			index.Sitemaps = append(index.Sitemaps, sitemapIndexRef{
+				Loc:     absURL(state.opts.BaseURL, "/"+name),
+				Lastmod: newestLastmod(chunk),
+			})
+			if _, err := state.planOutput(name, "sitemap", fingerprint(fp, name), func(destPath string) error {
+				return writeXMLFile(destPath, sitemapURLSet{XMLNS: sitemapXMLNS, URLs: chunk})
+			}); err != nil {
+				return err
+			}
+		}
+		if _, err := state.planOutput(sitemapFile, "sitemap", fingerprint(fp, "index"), func(destPath string) error {
+			return writeXMLFile(destPath, index)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return planRobots(state, absURL(state.opts.BaseURL, path.Join("/", sitemapFile)))
+}
+
+// planRobots schedules a robots.txt allowing everything and pointing to the
+// sitemap, if there is one, unless the project ships its own.
+func planRobots(state *buildState, sitemapURL string) error {
+	if _, taken := state.owners[robotsFile]; taken {
+		return nil
+	}
+	robots := "User-agent: *\nDisallow:\n"
+	if sitemapURL != "" {
+		robots += "\nSitemap: " + sitemapURL + "\n"
+	}
+	return planTextFile(state, robotsFile, "robots", robots)
+}
+
+// newestLastmod returns the latest lastmod of a chunk of entries. RFC 3339
+// timestamps in UTC sort as strings.
+func newestLastmod(urls []sitemapURL) string {
+	var newest string
+	for _, u := range urls {
+		if u.Lastmod > newest {
+			newest = u.Lastmod
+		}
+	}
+	return newest
+}
+
+// writeXMLFile writes v as an indented XML document to destPath.
+func writeXMLFile(destPath string, v interface{}) error {
+	outputFile, err := createOutputFile(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create output file %s: %w", destPath, err)
+	}
+	defer outputFile.Close()
+	return encodeXML(outputFile, v)
+}
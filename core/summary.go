@@ -0,0 +1,102 @@
+package core
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// A page's summary is, in this order of preference, its content up to a
+// <!--more--> line, its `summary` front matter rendered from Markdown, or the
+// first summaryLength words of its content as plain text.
+const (
+	moreDivider          = "<!--more-->"
+	defaultSummaryLength = 70
+	wordsPerMinute       = 200
+)
+
+// Summary returns the summary of the page's content, for list pages and feeds.
+func (p *Page) Summary() (template.HTML, error) {
+	rendered, err := p.content.get()
+	return rendered.summary, err
+}
+
+// Truncated reports whether the summary leaves out part of the content, so
+// that a "Read more" link is worth showing.
+func (p *Page) Truncated() (bool, error) {
+	rendered, err := p.content.get()
+	return rendered.truncated, err
+}
+
+// Plain returns the page's content as plain text, without any markup.
+func (p *Page) Plain() (string, error) {
+	rendered, err := p.content.get()
+	return rendered.plain, err
+}
+
+// WordCount returns the number of words in the page's content.
+func (p *Page) WordCount() (int, error) {
+	rendered, err := p.content.get()
+	return rendered.wordCount, err
+}
+
+// ReadingTime returns the minutes it takes to read the page's content,
+// rounded up.
+func (p *Page) ReadingTime() (int, error) {
+	rendered, err := p.content.get()
+	return readingTime(rendered.wordCount), err
+}
+
+// summarize fills in the summary, plain text and word count of rendered content.
+func (p *Page) summarize(rendered *renderedContent, opts markdownOptions) error {
+	rendered.plain = plainText(string(rendered.html))
+	words := strings.Fields(rendered.plain)
+	rendered.wordCount = len(words)
+
+	if before, after, found := strings.Cut(string(rendered.html), moreDivider); found {
+		rendered.summary = template.HTML(strings.TrimSpace(before))
+		rendered.truncated = strings.TrimSpace(after) != ""
+		return nil
+	}
+	if summary, ok := p.FrontMatter["summary"].(string); ok && summary != "" {
+		html, _, err := p.markdownToHTML([]byte(summary), opts, nil)
+		if err != nil {
+			return err
+		}
+		rendered.summary = template.HTML(strings.TrimSpace(string(html)))
+		rendered.truncated = true
+		return nil
+	}
+
+	length := opts.summaryLength
+	if length < 1 {
+		length = defaultSummaryLength
+	}
+	if len(words) <= length {
+		rendered.summary = template.HTML(template.HTMLEscapeString(rendered.plain))
+		return nil
+	}
+	rendered.summary = template.HTML(template.HTMLEscapeString(strings.Join(words[:length], " ")) + " …")
+	rendered.truncated = true
+	return nil
+}
+
+var (
+	// Tags that separate words; all others, like <em>, may sit inside a word.
+	blockTagPattern = regexp.MustCompile(`(?i)</?(?:address|article|aside|blockquote|br|dd|div|dl|dt|figcaption|figure|footer|h[1-6]|header|hr|li|nav|ol|p|pre|section|table|td|th|tr|ul)\b[^>]*>`)
+	htmlTagPattern  = regexp.MustCompile(`<[^>]*>`)
+)
+
+// plainText strips the tags from rendered HTML, decodes its entities and
+// collapses its white space.
+func plainText(s string) string {
+	s = blockTagPattern.ReplaceAllString(s, " ")
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// readingTime returns the minutes reading a number of words takes.
+func readingTime(words int) int {
+	return (words + wordsPerMinute - 1) / wordsPerMinute
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultTaxonomies are used when the build options do not declare any.
+var defaultTaxonomies = []string{"tags", "categories"}
+
+// buildTaxonomies collects the terms every page uses in the given taxonomies
+// and creates an overview page per taxonomy (/tags/) and a list page per
+// term (/tags/go/). Terms are matched by slug, so "Go" and "go" are one term,
+// titled the way it was first spelled.
+func (c *siteContent) buildTaxonomies(names []string) {
+	for _, name := range names {
+		taxonomy := &Page{
+			Kind:         KindTaxonomy,
+			Title:        humanize(name),
+			FrontMatter:  make(map[string]interface{}),
+			Section:      name,
+			RelPermalink: "/" + name + "/",
+			dir:          name,
+		}
+
+		terms := make(map[string]*Page)
+		for _, page := range c.pages {
+			for _, value := range frontMatterStrings(page.FrontMatter[name]) {
+				slug := termSlug(value)
+				if slug == "" {
+					continue
+				}
+				term, ok := terms[slug]
+				if !ok {
+					term = &Page{
+						Kind:         KindTerm,
+						Title:        value,
+						FrontMatter:  make(map[string]interface{}),
+						Section:      name,
+						RelPermalink: fmt.Sprintf("/%s/%s/", name, slug),
+						Parent:       taxonomy,
+						dir:          name + "/" + slug,
+					}
+					terms[slug] = term
+					taxonomy.Pages = append(taxonomy.Pages, term)
+				}
+				if page.hasTerm(name, term) {
+					continue
+				}
+				term.Pages = append(term.Pages, page)
+				if page.terms == nil {
+					page.terms = make(map[string][]*Page)
+				}
+				page.terms[name] = append(page.terms[name], term)
+			}
+		}
+
+		for _, term := range taxonomy.Pages {
+			sortPages(term.Pages)
+			term.regularPages = term.Pages
+		}
+		sortPages(taxonomy.Pages)
+		taxonomy.regularPages = taxonomy.Pages // The overview paginates its terms.
+		c.taxonomies = append(c.taxonomies, taxonomy)
+	}
+}
+
+// Terms returns the term pages of a taxonomy the page is classified with,
+// in the order its front matter lists them, e.g. {{ range .Terms "tags" }}.
+func (p *Page) Terms(taxonomy string) []*Page {
+	return p.terms[taxonomy]
+}
+
+func (p *Page) hasTerm(taxonomy string, term *Page) bool {
+	for _, t := range p.terms[taxonomy] {
+		if t == term {
+			return true
+		}
+	}
+	return false
+}
+
+// termSlug turns a term into the last segment of its URL. Terms made only of
+// characters slugify drops (e.g. non-Latin scripts) are URL-escaped instead.
+func termSlug(term string) string {
+	if slug := slugify(term); slug != "" {
+		return slug
+	}
+	return url.PathEscape(strings.ToLower(strings.TrimSpace(term)))
+}
+
+// termsFingerprint hashes what a taxonomy overview shows: its terms and how
+// many pages use each of them.
+func termsFingerprint(taxonomy *Page) string {
+	parts := make([]string, 0, len(taxonomy.Pages)*2)
+	for _, term := range taxonomy.Pages {
+		parts = append(parts, term.RelPermalink, term.Title, fmt.Sprint(len(term.Pages)))
+	}
+	return fingerprint(parts...)
+}
+
+// frontMatterStrings interprets a front matter value as a list of strings,
+// accepting both `tags: [a, b]` and `tags: a`.
+func frontMatterStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil
+		}
+		return []string{strings.TrimSpace(v)}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	case []string:
+		return v
+	}
+	return nil
+}
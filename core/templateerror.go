@@ -0,0 +1,169 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// templateErrorContext is the number of lines shown above and below the line
+// a template error is on.
+const templateErrorContext = 2
+
+// TemplateError is an error in a theme template, located in the template's
+// file. Errors that happen while rendering a page also name the page and its
+// content file.
+type TemplateError struct {
+	File    string       `json:"file"`             // The template relative to the project, e.g. "themes/blog/templates/page.html".
+	Line    int          `json:"line,omitempty"`   // 1-based; 0 when unknown.
+	Column  int          `json:"column,omitempty"` // 1-based byte offset in the line; 0 when unknown, as for parse errors.
+	Message string       `json:"message"`          // The error without its location.
+	Source  []SourceLine `json:"source,omitempty"` // The lines around Line.
+
+	Page        string `json:"page,omitempty"`        // The URL of the page being rendered.
+	Content     string `json:"content,omitempty"`     // Its content file relative to the project, e.g. "content/posts/hello.md".
+	ContentLine int    `json:"contentLine,omitempty"` // The line of the shortcode in Content, for errors in shortcodes.
+
+	err error
+}
+
+// SourceLine is one line of a template shown with a TemplateError.
+type SourceLine struct {
+	Number int    `json:"number"`
+	Text   string `json:"text"`
+	Error  bool   `json:"error,omitempty"` // Whether the error is on this line.
+}
+
+// templateSource is where a named template was read from.
+type templateSource struct {
+	file string
+	text string
+}
+
+// Location returns the template file and line, e.g. "layouts/page.html:12:5".
+func (e *TemplateError) Location() string {
+	location := e.File
+	if e.Line > 0 {
+		location += ":" + strconv.Itoa(e.Line)
+	}
+	if e.Line > 0 && e.Column > 0 {
+		location += ":" + strconv.Itoa(e.Column)
+	}
+	return location
+}
+
+func (e *TemplateError) Error() string {
+	msg := e.Location() + ": " + e.Message
+	switch {
+	case e.Content != "" && e.ContentLine > 0:
+		msg += fmt.Sprintf(" (rendering %s:%d)", e.Content, e.ContentLine)
+	case e.Content != "":
+		msg += " (rendering " + e.Content + ")"
+	case e.Page != "":
+		msg += " (rendering " + e.Page + ")"
+	}
+	return msg
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.err
+}
+
+// Excerpt formats the source lines around the error for a terminal, marking
+// the line the error is on.
+func (e *TemplateError) Excerpt() string {
+	var b strings.Builder
+	width := len(strconv.Itoa(e.Line + templateErrorContext))
+	for _, line := range e.Source {
+		marker := " "
+		if line.Error {
+			marker = ">"
+		}
+		fmt.Fprintf(&b, "%s %*d | %s\n", marker, width, line.Number, line.Text)
+	}
+	return b.String()
+}
+
+// TemplateErrors returns every TemplateError in err, which may join the
+// errors of many pages. An error reported by several pages, like one in the
+// content a list page shows the summary of, is returned once.
+func TemplateErrors(err error) []*TemplateError {
+	var found []*TemplateError
+	var walk func(err error)
+	walk = func(err error) {
+		if tmplErr, ok := err.(*TemplateError); ok {
+			if !slices.Contains(found, tmplErr) {
+				found = append(found, tmplErr)
+			}
+			return
+		}
+		switch err := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, err := range err.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(err.Unwrap())
+		}
+	}
+	if err != nil {
+		walk(err)
+	}
+	return found
+}
+
+// Go's template packages report errors as "template: NAME:LINE:COL: ..." for
+// execution and "template: NAME:LINE: ..." for parsing; html/template's own
+// errors start with "html/template:NAME:LINE:", or without a line when its
+// escaper cannot tell one.
+var templateErrorPattern = regexp.MustCompile(`^(?:html/template:|template: )(.+?)(?::(\d+))?(?::(\d+))?: (?s)(.*)$`)
+
+// locateError turns an error of Go's template packages into a TemplateError
+// pointing at the file the failing template was read from. Other errors are
+// returned unchanged.
+func (t *Theme) locateError(err error) error {
+	var tmplErr *TemplateError
+	if errors.As(err, &tmplErr) {
+		return err
+	}
+	groups := templateErrorPattern.FindStringSubmatch(err.Error())
+	if groups == nil {
+		return err
+	}
+	source, ok := t.sources[groups[1]]
+	if !ok {
+		return err
+	}
+	line, _ := strconv.Atoi(groups[2])
+	column, _ := strconv.Atoi(groups[3])
+	return &TemplateError{
+		File:    source.file,
+		Line:    line,
+		Column:  column,
+		Message: groups[4],
+		Source:  sourceLines(source.text, line),
+		err:     err,
+	}
+}
+
+// sourceLines returns the lines of text around a 1-based line number.
+func sourceLines(text string, line int) []SourceLine {
+	if line < 1 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	from := max(line-templateErrorContext, 1)
+	to := min(line+templateErrorContext, len(lines))
+	var source []SourceLine
+	for n := from; n <= to; n++ {
+		source = append(source, SourceLine{
+			Number: n,
+			Text:   strings.TrimRight(lines[n-1], "\r"),
+			Error:  n == line,
+		})
+	}
+	return source
+}
@@ -0,0 +1,421 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A theme's templates directory is organised like this:
+//
+//	templates/baseof.html          optional base layout, usually with {{ block }}s
+//	templates/partials/*.html      partials, usable as {{ template "header" . }}
+//	templates/shortcodes/*.html    shortcodes, usable in content as {{< name >}}
+//	templates/page.html            the default layout of a single page
+//	templates/<section>/page.html  the layout of pages in one section
+//	templates/index.html           the home page
+//	templates/list.html            the default layout of a section's list page
+//	templates/<section>/list.html  the list page of one section
+//	templates/taxonomy.html        the overview of a taxonomy's terms, e.g. /tags/
+//	templates/term.html            the list page of one term, e.g. /tags/go/
+//	templates/404.html             the error page, written to 404.html
+//	templates/<name>.html          a layout chosen with `layout: <name>`
+//
+// A layout that only defines blocks ({{ define "main" }}...{{ end }}) is
+// rendered through baseof.html; a layout with content of its own is rendered
+// as it is, so complete documents keep working without a base layout.
+//
+// A theme can build on another one by naming it in its theme.yaml:
+//
+//	extends: base
+//
+// Every template and static file the theme lacks is then taken from its
+// parent, and so on up the chain. The project's own layouts/ and static/
+// directories come first of all, so a site can override single files of its
+// theme without copying it.
+const (
+	baseLayoutName = "baseof"
+	partialsDir    = "partials"
+	themeFile      = "theme.yaml"
+	notFoundLayout = "404"
+
+	projectLayoutsDir = "layouts"
+	projectStaticDir  = "static"
+)
+
+// Theme holds the parsed templates of a project's theme and its parents.
+type Theme struct {
+	name    string
+	layouts map[string]*layout // Keyed by path relative to templates/, without extension, e.g. "posts/page".
+
+	// shortcodes are keyed by name; each can use the partials.
+	shortcodes map[string]*template.Template
+
+	// shortcodeUses is what the shortcodes and the partials they call
+	// depend on; every page with content depends on it too.
+	shortcodeUses templateUses
+
+	// sources maps the names templates are parsed under to the files they
+	// were read from, to locate errors.
+	sources map[string]templateSource
+
+	// The layers templates and static files are looked up in, the project's
+	// own first, then the theme's, then its parents'.
+	templateLayers []themeLayer
+	staticLayers   []themeLayer
+}
+
+// themeLayer is one place templates or static files come from: a directory
+// of the project or the built-in default theme.
+type themeLayer struct {
+	name string // Shown in messages and events, e.g. "themes/blog/static".
+	fsys fs.FS
+}
+
+// themeManifest is the content of a theme's theme.yaml.
+type themeManifest struct {
+	Extends string `yaml:"extends"`
+}
+
+// layout is a ready to execute template set: the layout itself, the base
+// layout and every partial.
+type layout struct {
+	tmpl  *template.Template
+	entry string       // The template to execute, either the layout or the base layout.
+	uses  templateUses // What the set depends on beyond the page it renders.
+}
+
+// themeChain resolves a theme and the themes it extends, the theme itself
+// first. A theme named "default" that the project does not have is the
+// built-in default theme.
+func themeChain(projectPath, name string) ([]themeLayer, error) {
+	var names []string
+	var chain []themeLayer
+	for name != "" {
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("themes extend each other in a cycle: %s -> %s", strings.Join(names, " -> "), name)
+		}
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("theme name '%s' must be the name of a directory in themes/", name)
+		}
+		names = append(names, name)
+
+		themeDir := filepath.Join(projectPath, "themes", name)
+		switch _, err := os.Stat(themeDir); {
+		case err == nil:
+			chain = append(chain, themeLayer{name: "themes/" + name, fsys: os.DirFS(themeDir)})
+		case name == defaultThemeName:
+			chain = append(chain, themeLayer{name: "built-in theme", fsys: builtinTheme()})
+		case len(chain) == 0:
+			return nil, fmt.Errorf("theme '%s' not found in %s", name, filepath.Join(projectPath, "themes"))
+		default:
+			return nil, fmt.Errorf("theme '%s' extends '%s', which is not found in %s", names[len(names)-2], name, filepath.Join(projectPath, "themes"))
+		}
+
+		var manifest themeManifest
+		data, err := fs.ReadFile(chain[len(chain)-1].fsys, themeFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("could not read %s of theme '%s': %w", themeFile, name, err)
+		}
+		if err := yaml.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("could not parse %s of theme '%s': %w", themeFile, name, err)
+		}
+		name = manifest.Extends
+	}
+	return chain, nil
+}
+
+// subLayer returns the layer of a directory inside a theme, e.g. its templates.
+func (l themeLayer) subLayer(dir string) themeLayer {
+	sub, err := fs.Sub(l.fsys, dir)
+	if err != nil {
+		// fs.Sub only fails for invalid names, never for constants like ours.
+		panic(err)
+	}
+	return themeLayer{name: l.name + "/" + dir, fsys: sub}
+}
+
+// walk calls fn for every file in the layer. A layer whose directory does not
+// exist has no files.
+func (l themeLayer) walk(fn func(relPath string) error) error {
+	return fs.WalkDir(l.fsys, ".", func(relPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && relPath == "." {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		return fn(relPath)
+	})
+}
+
+// loadTheme parses the templates of a project's theme, its parent themes and
+// the project's layouts/ directory into one template set. The build options
+// configure the template functions, e.g. absURL's base URL.
+func loadTheme(projectPath, name string, opts BuildOptions) (*Theme, error) {
+	chain, err := themeChain(projectPath, name)
+	if err != nil {
+		return nil, err
+	}
+	theme := &Theme{
+		name:           name,
+		layouts:        make(map[string]*layout),
+		shortcodes:     make(map[string]*template.Template),
+		sources:        make(map[string]templateSource),
+		templateLayers: []themeLayer{{name: projectLayoutsDir, fsys: os.DirFS(filepath.Join(projectPath, projectLayoutsDir))}},
+		staticLayers:   []themeLayer{{name: projectStaticDir, fsys: os.DirFS(filepath.Join(projectPath, projectStaticDir))}},
+	}
+	for _, layer := range chain {
+		theme.templateLayers = append(theme.templateLayers, layer.subLayer("templates"))
+		theme.staticLayers = append(theme.staticLayers, layer.subLayer("static"))
+	}
+
+	var baseSource string
+	partials := make(map[string]string)
+	shortcodes := make(map[string]string)
+	layouts := make(map[string]string)
+
+	// Read the most distant parent first, so every closer layer overrides
+	// the files it also has.
+	for i := len(theme.templateLayers) - 1; i >= 0; i-- {
+		layer := theme.templateLayers[i]
+		err := layer.walk(func(relPath string) error {
+			if !strings.HasSuffix(relPath, ".html") {
+				return nil
+			}
+			name := strings.TrimSuffix(relPath, ".html")
+
+			data, err := fs.ReadFile(layer.fsys, relPath)
+			if err != nil {
+				return err
+			}
+
+			// Shortcodes are parsed under their path, everything else
+			// under the name it is used by.
+			tmplName := name
+			switch {
+			case name == baseLayoutName:
+				baseSource = string(data)
+			case strings.HasPrefix(name, partialsDir+"/"):
+				tmplName = strings.TrimPrefix(name, partialsDir+"/")
+				partials[tmplName] = string(data)
+			case strings.HasPrefix(name, shortcodesDir+"/"):
+				shortcodes[strings.TrimPrefix(name, shortcodesDir+"/")] = string(data)
+			default:
+				layouts[name] = string(data)
+			}
+			theme.sources[tmplName] = templateSource{file: layer.name + "/" + relPath, text: string(data)}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not read theme templates in '%s': %w", layer.name, err)
+		}
+	}
+
+	// 1. Parse everything layouts share once: the partials and the base layout.
+	common := template.New("").Funcs(templateFuncs(opts))
+	for _, name := range sortedKeys(partials) {
+		if _, err := common.New(name).Parse(partials[name]); err != nil {
+			return nil, fmt.Errorf("could not parse partial '%s': %w", name, theme.locateError(err))
+		}
+	}
+	if baseSource != "" {
+		if _, err := common.New(baseLayoutName).Parse(baseSource); err != nil {
+			return nil, fmt.Errorf("could not parse base layout: %w", theme.locateError(err))
+		}
+	}
+
+	// 2. Give every layout its own copy of the shared set, so the blocks one
+	// layout overrides do not leak into the others.
+	for _, name := range sortedKeys(layouts) {
+		set, err := common.Clone()
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := set.New(name).Parse(layouts[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse layout '%s': %w", name, theme.locateError(err))
+		}
+
+		entry := name
+		if baseSource != "" && !hasOwnContent(tmpl.Tree) {
+			entry = baseLayoutName
+		}
+		theme.layouts[name] = &layout{tmpl: set, entry: entry, uses: usesOf(set)}
+	}
+
+	// 3. Shortcodes get a copy of the shared set too, under a name of their
+	// own so they cannot clash with a partial.
+	for _, name := range sortedKeys(shortcodes) {
+		set, err := common.Clone()
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := set.New(shortcodesDir + "/" + name).Parse(shortcodes[name])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse shortcode '%s': %w", name, theme.locateError(err))
+		}
+		theme.shortcodes[name] = tmpl
+		theme.shortcodeUses = theme.shortcodeUses.or(usesOf(set))
+	}
+
+	return theme, nil
+}
+
+// hasOwnContent reports whether a template renders anything by itself,
+// as opposed to only defining blocks for the base layout.
+func hasOwnContent(tree *parse.Tree) bool {
+	if tree == nil || tree.Root == nil {
+		return false
+	}
+	for _, node := range tree.Root.Nodes {
+		if text, ok := node.(*parse.TextNode); ok && strings.TrimSpace(string(text.Text)) == "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// singleLayoutCandidates lists, most specific first, the layouts that may
+// render a single page of the given section, optionally with a `layout:`
+// front matter override.
+func singleLayoutCandidates(section, layoutName string) []string {
+	var candidates []string
+	if layoutName != "" {
+		if section != "" {
+			candidates = append(candidates, section+"/"+layoutName)
+		}
+		candidates = append(candidates, layoutName)
+	}
+	if section != "" {
+		candidates = append(candidates, section+"/page")
+	}
+	return append(candidates, "page")
+}
+
+// listLayoutCandidates lists, most specific first, the layouts that may render
+// a list page: "index" or "list" for the home page, "<section>/list" or
+// "list" for a section, each preceded by a `layout:` override from _index.md.
+func listLayoutCandidates(kind, section, layoutName string) []string {
+	var candidates []string
+	if layoutName != "" {
+		if section != "" {
+			candidates = append(candidates, section+"/"+layoutName)
+		}
+		candidates = append(candidates, layoutName)
+	}
+	if kind == KindHome {
+		candidates = append(candidates, "index")
+	} else if section != "" {
+		candidates = append(candidates, section+"/list")
+	}
+	return append(candidates, "list")
+}
+
+// taxonomyLayoutCandidates lists the layouts that may render a taxonomy
+// overview ("taxonomy") or a term's list page ("term"), falling back to the
+// plain list layout. Both can be specialised per taxonomy, e.g. "tags/term".
+func taxonomyLayoutCandidates(kind, taxonomy string) []string {
+	return []string{taxonomy + "/" + kind, kind, "list"}
+}
+
+// hasAny reports whether the theme has at least one of the candidate layouts.
+func (t *Theme) hasAny(candidates []string) bool {
+	for _, name := range candidates {
+		if _, ok := t.layouts[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasListLayouts reports whether the theme can render list pages at all.
+// Older themes only ship page.html; their sites simply get no list pages.
+func (t *Theme) hasListLayouts() bool {
+	for name := range t.layouts {
+		if name == "index" || name == "list" || strings.HasSuffix(name, "/list") {
+			return true
+		}
+	}
+	return false
+}
+
+// execute renders data with the first of the candidate layouts the theme has.
+func (t *Theme) execute(w io.Writer, candidates []string, data interface{}) error {
+	for _, name := range candidates {
+		if l, ok := t.layouts[name]; ok {
+			return l.tmpl.ExecuteTemplate(w, l.entry, data)
+		}
+	}
+	return fmt.Errorf("no layout found in theme '%s' (tried %s)", t.name, strings.Join(candidates, ", "))
+}
+
+// templateUses is what a template set depends on besides the page it
+// renders and the templates themselves.
+type templateUses struct {
+	data  bool // It refers to .Site.Data.
+	pages bool // It refers to .Site.Pages or .Site.RegularPages.
+	now   bool // It calls now, so it renders differently on every build.
+}
+
+// usesOf finds out what a template set depends on.
+func usesOf(set *template.Template) templateUses {
+	return templateUses{
+		data:  templatesUseData(set),
+		pages: templatesUseSitePages(set),
+		now:   templatesUseNow(set),
+	}
+}
+
+func (u templateUses) or(v templateUses) templateUses {
+	return templateUses{data: u.data || v.data, pages: u.pages || v.pages, now: u.now || v.now}
+}
+
+// uses reports what pages rendered with the first of the candidate layouts
+// the theme has depend on, the shortcodes in their content included.
+func (t *Theme) uses(candidates []string) templateUses {
+	for _, name := range candidates {
+		if l, ok := t.layouts[name]; ok {
+			return l.uses.or(t.shortcodeUses)
+		}
+	}
+	return templateUses{}
+}
+
+// hash fingerprints every template of every layer, so that a change anywhere
+// in the chain, or in the chain itself, invalidates the rendered pages.
+func (t *Theme) hash() (string, error) {
+	var parts []string
+	for _, layer := range t.templateLayers {
+		layerHash, err := hashFS(layer.fsys)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, layer.name, layerHash)
+	}
+	return fingerprint(parts...), nil
+}
+
+// sortedKeys returns the keys of a map in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// Default depth of .TableOfContents: the headings of the sections of a page,
+// ## and ###, leaving out the # that usually repeats its title.
+const (
+	defaultTOCStartLevel = 2
+	defaultTOCEndLevel   = 3
+)
+
+// HeadingOptions controls the table of contents and the headings of content.
+type HeadingOptions struct {
+	TOCStartLevel int  `yaml:"tocStartLevel" json:"tocStartLevel" toml:"tocStartLevel"` // The highest heading level listed in .TableOfContents.
+	TOCEndLevel   int  `yaml:"tocEndLevel" json:"tocEndLevel" toml:"tocEndLevel"`       // The lowest heading level listed in .TableOfContents.
+	Anchors       bool `yaml:"anchors" json:"anchors" toml:"anchors"`                   // Put a link to itself next to every heading.
+}
+
+// Heading is a heading of a page's content, as templates see it in .Headings.
+type Heading struct {
+	Level    int        // 1 for #, 2 for ## and so on.
+	ID       string     // The id of the heading element, for links like "#install".
+	Title    string     // The heading as plain text.
+	Children []*Heading // The headings of the next levels up to the next heading of this level.
+}
+
+// collectHeadings lists the headings of a parsed Markdown document in order.
+// headingText, if not nil, maps the text of every heading to its title.
+func collectHeadings(doc ast.Node, headingText func(string) string) []*Heading {
+	var headings []*Heading
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || heading.IsTitleblock {
+			return ast.GoToNext
+		}
+		title := nodeText(heading)
+		if headingText != nil {
+			title = headingText(title)
+		}
+		headings = append(headings, &Heading{
+			Level: heading.Level,
+			ID:    heading.HeadingID,
+			Title: strings.TrimSpace(title),
+		})
+		return ast.SkipChildren
+	})
+	return headings
+}
+
+// retitleHeadings gives every heading whose text headingText changes, and
+// whose id was made from that text, an id made from the changed text
+// instead. Ids stay unique the way the Markdown parser keeps them unique,
+// with a number appended.
+func retitleHeadings(doc ast.Node, headingText func(string) string) {
+	var headings []*ast.Heading
+	taken := make(map[string]bool)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if heading, ok := node.(*ast.Heading); ok && entering {
+			headings = append(headings, heading)
+			taken[heading.HeadingID] = true
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+
+	for _, heading := range headings {
+		text := nodeText(heading)
+		resolved := headingText(text)
+		auto := headingID(text)
+		if resolved == text || heading.HeadingID != auto && !strings.HasPrefix(heading.HeadingID, auto+"-") {
+			continue
+		}
+		delete(taken, heading.HeadingID)
+		id := headingID(resolved)
+		for n := 1; taken[id]; n++ {
+			id = headingID(resolved) + "-" + strconv.Itoa(n)
+		}
+		heading.HeadingID = id
+		taken[id] = true
+	}
+}
+
+// headingID makes the id of a heading from its text the way the Markdown
+// parser does: lower case letters and digits, with a dash for every run of
+// other characters between them.
+func headingID(text string) string {
+	var id []rune
+	dash := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) {
+			dash = true
+			continue
+		}
+		if dash && len(id) > 0 {
+			id = append(id, '-')
+		}
+		dash = false
+		id = append(id, unicode.ToLower(r))
+	}
+	if len(id) == 0 {
+		return "empty"
+	}
+	return string(id)
+}
+
+// nodeText returns the text of a node and its children without any markup.
+func nodeText(node ast.Node) string {
+	var b strings.Builder
+	ast.WalkFunc(node, func(node ast.Node, entering bool) ast.WalkStatus {
+		if leaf := node.AsLeaf(); leaf != nil && entering {
+			b.Write(leaf.Literal)
+		}
+		return ast.GoToNext
+	})
+	return b.String()
+}
+
+// nestHeadings turns a list of headings into a tree. A heading belongs to the
+// closest heading of a higher level before it, even if levels are skipped.
+// The headings in the tree are copies, so one list can be nested many ways.
+func nestHeadings(headings []*Heading) []*Heading {
+	var roots, open []*Heading
+	for _, heading := range headings {
+		node := &Heading{Level: heading.Level, ID: heading.ID, Title: heading.Title}
+		for len(open) > 0 && open[len(open)-1].Level >= node.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			roots = append(roots, node)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, node)
+		}
+		open = append(open, node)
+	}
+	return roots
+}
+
+// tableOfContents renders the headings between the configured levels as
+// nested lists, or nothing if there are none.
+func tableOfContents(headings []*Heading, opts HeadingOptions) template.HTML {
+	var listed []*Heading
+	for _, heading := range headings {
+		if heading.Level >= opts.TOCStartLevel && heading.Level <= opts.TOCEndLevel && heading.ID != "" {
+			listed = append(listed, heading)
+		}
+	}
+	if len(listed) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="toc">`)
+	writeTOCList(&b, nestHeadings(listed))
+	b.WriteString("</nav>")
+	return template.HTML(b.String())
+}
+
+func writeTOCList(b *strings.Builder, headings []*Heading) {
+	b.WriteString("<ul>")
+	for _, heading := range headings {
+		fmt.Fprintf(b, `<li><a href="#%s">%s</a>`, template.HTMLEscapeString(heading.ID), template.HTMLEscapeString(heading.Title))
+		if len(heading.Children) > 0 {
+			writeTOCList(b, heading.Children)
+		}
+		b.WriteString("</li>")
+	}
+	b.WriteString("</ul>")
+}
+
+// writeHeadingAnchor puts a link to a heading at its end, just before the
+// renderer closes it.
+func writeHeadingAnchor(w io.Writer, node ast.Node, entering bool) {
+	heading, ok := node.(*ast.Heading)
+	if !ok || entering || heading.HeadingID == "" || heading.IsTitleblock {
+		return
+	}
+	fmt.Fprintf(w, ` <a class="heading-anchor" href="#%s" aria-label="Link to this section">#</a>`, template.HTMLEscapeString(heading.HeadingID))
+}
@@ -20,6 +20,7 @@ func main() {
 		// Since we are using a live web server, we don't use the AssetServer.
 		// We tell Wails to load its content from our internal server's URL.
 		StartHidden: false, // Start the window visible
+		OnStartup:   app.startup,
 		Bind: []interface{}{
 			app,
 		},
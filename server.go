@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
@@ -22,6 +23,8 @@ func createWebServerMux(a *App) http.Handler {
 	e.GET("/api/ui/projects", listProjectsHandler(a))
 	e.GET("/api/ui/project/:name", projectDashboardHandler(a))
 	e.POST("/api/ui/project/:name/build", handleBuildProject(a))
+	e.GET("/api/ui/project/:name/build/events", buildEventsHandler(a))
+	e.POST("/api/ui/project/:name/preview", handlePreviewProject(a))
 
 	e.GET("/api/ui/editor/:name/new", showNewEditorHandler(a))
 	e.POST("/api/ui/save-article/:name", handleSaveArticleHandler(a))
@@ -62,7 +65,7 @@ func projectDashboardHandler(a *App) echo.HandlerFunc {
 			return c.String(http.StatusNotFound, err.Error())
 		}
 
-		files, err := a.engine.ListContentFiles(projectName)
+		files, err := a.engine.ContentStatus(projectName)
 
 		if err != nil {
 			return c.String(http.StatusInternalServerError, err.Error())
@@ -77,7 +80,8 @@ func handleBuildProject(a *App) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		projectName := c.Param("name")
 
-		err := a.engine.BuildProject(projectName)
+		// Tie the build to the request, so a closed window does not keep building.
+		report, err := a.engine.BuildProjectContext(c.Request().Context(), projectName, core.BuildOptions{})
 
 		// Prepare data for the feedback template
 		data := map[string]interface{}{
@@ -85,18 +89,89 @@ func handleBuildProject(a *App) echo.HandlerFunc {
 			"Timestamp":   time.Now().UnixNano(), // Unique ID for the toast element
 		}
 
+		// The build errors panel of the dashboard is swapped out of band
+		// along with the toast, so a successful build clears it again.
 		if err != nil {
 			log.Printf("ERROR: Build failed for project '%s': %v", projectName, err)
 			data["Error"] = err.Error()
-			return renderTemplate(c, "toast-error.html", data)
+			data["TemplateErrors"] = core.TemplateErrors(err)
+			if err := renderTemplate(c, filepath.Join("partials", "toast-error.html"), data); err != nil {
+				return err
+			}
+			return renderTemplate(c, filepath.Join("partials", "build-errors.html"), data)
 		}
 
 		runtime.LogInfof(a.ctx, "SUCCESS: Project '%s' built successfully.", projectName)
-		data["Message"] = fmt.Sprintf("Project '%s' built successfully.", projectName)
+		data["Message"] = fmt.Sprintf("Project '%s' built successfully: %d rebuilt, %d skipped, %d deleted.",
+			projectName, report.Rebuilt, report.Skipped, report.Deleted)
+		if err := renderTemplate(c, filepath.Join("partials", "toast-success.html"), data); err != nil {
+			return err
+		}
+		return renderTemplate(c, filepath.Join("partials", "build-errors.html"), data)
+	}
+}
+
+// handlePreviewProject starts (or reuses) the live reload preview server of a
+// project and opens it in the system browser.
+func handlePreviewProject(a *App) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		projectName := c.Param("name")
+		data := map[string]interface{}{
+			"ProjectName": projectName,
+			"Timestamp":   time.Now().UnixNano(),
+		}
+
+		preview, err := a.startPreview(projectName)
+		if err != nil {
+			log.Printf("ERROR: Preview failed for project '%s': %v", projectName, err)
+			data["Error"] = err.Error()
+			return renderTemplate(c, filepath.Join("partials", "toast-error.html"), data)
+		}
+
+		runtime.BrowserOpenURL(a.ctx, preview.URL())
+		data["Message"] = fmt.Sprintf("Previewing '%s' at %s", projectName, preview.URL())
 		return renderTemplate(c, filepath.Join("partials", "toast-success.html"), data)
 	}
 }
 
+// buildEventsHandler streams the progress events of a project's builds to the
+// dashboard as Server-Sent Events until the client goes away.
+func buildEventsHandler(a *App) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		projectName := c.Param("name")
+
+		events, unsubscribe := a.engine.SubscribeBuildEvents()
+		defer unsubscribe()
+
+		w := c.Response()
+		w.Header().Set(echo.HeaderContentType, "text/event-stream")
+		w.Header().Set(echo.HeaderCacheControl, "no-cache")
+		w.Header().Set(echo.HeaderConnection, "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case event, ok := <-events:
+				if !ok {
+					return nil
+				}
+				if event.Project != projectName {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "event: build\ndata: %s\n\n", data)
+				w.Flush()
+			}
+		}
+	}
+}
+
 func saveFileHandler(a *App) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		projectName := c.Param("name")
@@ -171,11 +246,19 @@ func handleSaveArticleHandler(a *App) echo.HandlerFunc {
 
 		articeData := &core.Article{
 			FrontMatter: core.ArticleFrontMatter{
-				Title: title,
-				Date:  time.Now(),
+				Date: time.Now(),
 			},
-			Body: body,
 		}
+		// When editing, start from what is on disk so fields the editor does not show survive.
+		if originalPath != "" {
+			if existing, err := a.engine.ParseArticleFile(projectName, originalPath); err == nil {
+				articeData = existing
+			}
+		}
+		articeData.FrontMatter.Title = title
+		articeData.FrontMatter.Tags = core.ParseStringList(c.FormValue("tags"))
+		articeData.FrontMatter.Categories = core.ParseStringList(c.FormValue("categories"))
+		articeData.Body = body
 
 		finalPath, err := a.engine.SaveArticle(projectName, articeData, originalPath)
 